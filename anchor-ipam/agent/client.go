@@ -0,0 +1,122 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+// ErrUnavailable means no agent is listening, and the caller should do the
+// work itself.
+var ErrUnavailable = errors.New("anchor agent is not running")
+
+// Client calls the agent of the node.
+type Client struct {
+	socket string
+	http   *http.Client
+}
+
+func NewClient(socket string) *Client {
+	if socket == "" {
+		socket = DefaultSocket
+	}
+	return &Client{
+		socket: socket,
+		http: &http.Client{
+			Transport: &http.Transport{
+				Dial: func(_, _ string) (net.Conn, error) {
+					return net.DialTimeout("unix", socket, time.Second)
+				},
+			},
+			// Allocation waits for the etcd lock, which may take a while
+			// when many pods start at once.
+			Timeout: 2 * time.Minute,
+		},
+	}
+}
+
+// Allocate returns the result of an ADD.
+func (c *Client) Allocate(req *Request) (*current.Result, error) {
+	result := &current.Result{}
+	if err := c.call(pathAllocate, req, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Release does a DEL.
+func (c *Client) Release(req *Request) error {
+	return c.call(pathRelease, req, nil)
+}
+
+// Check returns an error if the container has no address.
+func (c *Client) Check(req *Request) error {
+	return c.call(pathCheck, req, nil)
+}
+
+func (c *Client) call(path string, req *Request, out interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	// The host part is ignored, Dial always goes to the socket.
+	resp, err := c.http.Post("http://agent"+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		if isUnavailable(err) {
+			return ErrUnavailable
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &errorResponse{}
+		if err := json.Unmarshal(data, e); err != nil || e.Error == "" {
+			return fmt.Errorf("anchor agent returned %s", resp.Status)
+		}
+		return errors.New(e.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// isUnavailable is true when nothing listens on the socket, as opposed to an
+// agent failing to answer.
+func isUnavailable(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		return opErr.Op == "dial"
+	}
+	return false
+}
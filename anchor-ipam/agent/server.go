@@ -0,0 +1,172 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+	"github.com/daocloud/anchor/anchor-ipam/k8s"
+	"github.com/daocloud/anchor/anchor-ipam/metrics"
+	"github.com/daocloud/anchor/anchor-ipam/plugin"
+)
+
+// OpenStoreFunc connects to the etcd store of the network of a request.
+type OpenStoreFunc func(ipamConf *allocator.IPAMConfig) (backend.Store, error)
+
+// Server serves the CNI calls of a node with long-lived clients.
+type Server struct {
+	// The stores by etcd prefix. Every network of the node shares the
+	// socket, so each request goes to the store of its own network, opened
+	// on its first request.
+	stores    map[string]backend.Store
+	openStore OpenStoreFunc
+	k8sClient *k8s.Client
+
+	// The etcd mutex of a store is held per session, and every request
+	// shares the session of the agent, so requests take turns here first.
+	mu sync.Mutex
+}
+
+// NewServer returns a server with store as the store of prefix, and
+// openStore to connect to the stores of the other networks.
+func NewServer(prefix string, store backend.Store, openStore OpenStoreFunc, k8sClient *k8s.Client) *Server {
+	return &Server{
+		stores:    map[string]backend.Store{prefix: store},
+		openStore: openStore,
+		k8sClient: k8sClient,
+	}
+}
+
+// Handler returns the HTTP handler of the API, for callers that want to add
+// their own endpoints next to it.
+func (s *Server) Handler() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathAllocate, s.handle(s.allocate))
+	mux.HandleFunc(pathRelease, s.handle(s.release))
+	mux.HandleFunc(pathCheck, s.handle(s.check))
+	return mux
+}
+
+// ListenAndServe serves the API on the unix socket at path, replacing any
+// stale socket left by a previous agent.
+func (s *Server) ListenAndServe(path string, handler http.Handler) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	if err := os.Chmod(path, 0600); err != nil {
+		return err
+	}
+	return http.Serve(l, handler)
+}
+
+func (s *Server) handle(f func(req *Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := &Request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeJSON(w, http.StatusBadRequest, &errorResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+
+		s.mu.Lock()
+		resp, err := f(req)
+		s.mu.Unlock()
+		if err != nil {
+			log.Printf("%s %s: %v", r.URL.Path, req.ContainerID, err)
+			writeJSON(w, http.StatusInternalServerError, &errorResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// store returns the config of the network of a request and its store.
+func (s *Server) store(req *Request) (*allocator.IPAMConfig, backend.Store, error) {
+	ipamConf, _, err := allocator.LoadIPAMConfig(req.StdinData, req.Args)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := ipamConf.EtcdPrefix()
+	if store, ok := s.stores[prefix]; ok {
+		return ipamConf, store, nil
+	}
+
+	store, err := s.openStore(ipamConf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to the store of %s: %v", prefix, err)
+	}
+	log.Printf("serving the network keyed under %s", prefix)
+	s.stores[prefix] = store
+	return ipamConf, store, nil
+}
+
+func (s *Server) allocate(req *Request) (interface{}, error) {
+	ipamConf, store, err := s.store(req)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	result, err := plugin.Add(ipamConf, req.ContainerID, req.Args, store, s.k8sClient)
+	metrics.ObserveAllocation(time.Since(start).Seconds(), err)
+	return result, err
+}
+
+func (s *Server) release(req *Request) (interface{}, error) {
+	_, store, err := s.store(req)
+	if err != nil {
+		return nil, err
+	}
+	err = plugin.Del(store, req.ContainerID)
+	metrics.ObserveRelease(err)
+	return struct{}{}, err
+}
+
+func (s *Server) check(req *Request) (interface{}, error) {
+	_, store, err := s.store(req)
+	if err != nil {
+		return nil, err
+	}
+	return struct{}{}, plugin.Check(store, req.ContainerID)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,42 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package agent is the node-local anchor daemon. It keeps the etcd and
+// Kubernetes clients the CNI binary would otherwise create on every call, and
+// serves allocate, release and check on a unix socket.
+package agent
+
+// DefaultSocket is where the agent listens, and where anchor-ipam looks for it.
+const DefaultSocket = "/var/run/anchor/agent.sock"
+
+// Paths of the API.
+const (
+	pathAllocate = "/v1/allocate"
+	pathRelease  = "/v1/release"
+	pathCheck    = "/v1/check"
+)
+
+// Request is what the CNI binary got from the runtime.
+type Request struct {
+	ContainerID string `json:"container_id"`
+	// Args is CNI_ARGS, with the pod name and namespace.
+	Args string `json:"args"`
+	// StdinData is the network config.
+	StdinData []byte `json:"stdin_data"`
+}
+
+// errorResponse is the body of failed requests.
+type errorResponse struct {
+	Error string `json:"error"`
+}
@@ -0,0 +1,127 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Account is who makes a request.
+type Account struct {
+	Name    string
+	IsAdmin bool
+}
+
+// Authenticator tells who makes a request, and the tenants they see.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Account, error)
+	// Tenants returns the names of the tenants of the account of r, nil
+	// meaning every tenant.
+	Tenants(r *http.Request) ([]string, error)
+}
+
+// NoAuth lets everyone in as an administrator, for deployments where the API
+// is only reachable by administrators.
+type NoAuth struct{}
+
+func (NoAuth) Authenticate(r *http.Request) (*Account, error) {
+	return &Account{IsAdmin: true}, nil
+}
+
+func (NoAuth) Tenants(r *http.Request) ([]string, error) {
+	return nil, nil
+}
+
+// DCEAuth asks DCE who owns the token of a request, the way the governor
+// does: from the X-DCE-Access-Token header, or else the DCE_TOKEN cookie.
+type DCEAuth struct {
+	// BaseURL of DCE, such as https://10.0.0.2:443.
+	BaseURL string
+	client  *http.Client
+}
+
+func NewDCEAuth(baseURL string) *DCEAuth {
+	return &DCEAuth{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			// DCE serves a self-signed certificate.
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		},
+	}
+}
+
+func dceToken(r *http.Request) string {
+	if token := r.Header.Get("X-DCE-Access-Token"); token != "" {
+		return token
+	}
+	if c, err := r.Cookie("DCE_TOKEN"); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+func (a *DCEAuth) get(r *http.Request, path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, a.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	if token := dceToken(r); token != "" {
+		req.Header.Set("X-DCE-Access-Token", token)
+	}
+	req.Header.Set("User-Agent", "DCE-plugin/static-ip")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Authenticate returns an anonymous account when DCE doesn't know the token,
+// as the governor does, which can't do anything as it has no tenant.
+func (a *DCEAuth) Authenticate(r *http.Request) (*Account, error) {
+	account := &struct {
+		Name    string
+		IsAdmin bool
+	}{}
+	if err := a.get(r, "/dce/my-account", account); err != nil {
+		return &Account{}, nil
+	}
+	return &Account{Name: account.Name, IsAdmin: account.IsAdmin}, nil
+}
+
+func (a *DCEAuth) Tenants(r *http.Request) ([]string, error) {
+	tenants := []struct {
+		Name string
+	}{}
+	if err := a.get(r, "/dce/tenants", &tenants); err != nil {
+		return []string{}, nil
+	}
+	ret := make([]string, 0, len(tenants))
+	for _, t := range tenants {
+		ret = append(ret, t.Name)
+	}
+	return ret, nil
+}
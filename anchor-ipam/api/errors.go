@@ -0,0 +1,67 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Error ids of the governor API, which the UI knows.
+const (
+	errAlreadyExist       = "static_ip_already_exist_error"
+	errNotExist           = "static_ip_not_exist_error"
+	errNotAuthorized      = "not_authorized_error"
+	errAlreadyAssigned    = "static_ip_already_assigned_error"
+	errFormat             = "static_ip_format_error"
+	errRangeTooBig        = "static_ip_range_too_big"
+	errRange              = "static_ip_range_err"
+	errNotBelongToTenant  = "static_ip_not_belong_to_tenant"
+	errGatewayNotInSubnet = "sip_ip_gateway_not_in_subnet_error"
+	errGatewayNotExist    = "sip_ip_gateway_not_exist_error"
+	errGatewayFormat      = "sip_ip_gateway_format_error"
+	errSubnetAlreadyExist = "subnet_already_exist_error"
+	errBadRequest         = "unknown_exception"
+	errInternal           = "internal_error"
+)
+
+// Error is an error of the API, returned as {"id": ..., "message": ...}.
+type Error struct {
+	Code    int    `json:"-"`
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.ID + ": " + e.Message
+}
+
+// badRequest is a 400 error. Like the governor, the message defaults to the
+// id.
+func badRequest(id string, format string, a ...interface{}) *Error {
+	message := fmt.Sprintf(format, a...)
+	if message == "" {
+		message = id
+	}
+	return &Error{Code: http.StatusBadRequest, ID: id, Message: message}
+}
+
+// apiError returns err as an API error, a 500 unless it is one already.
+func apiError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Code: http.StatusInternalServerError, ID: errInternal, Message: err.Error()}
+}
@@ -0,0 +1,115 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net"
+	"net/http"
+)
+
+// Gateway is the gateway of a subnet.
+type Gateway struct {
+	Gateway string
+	Subnet  string
+}
+
+func (s *Server) listGateways(r *http.Request, account *Account) (int, interface{}, error) {
+	gateways, err := s.store.ListGateways()
+	if err != nil {
+		return 0, nil, err
+	}
+	ret := []*Gateway{}
+	for _, gw := range gateways {
+		ret = append(ret, &Gateway{Gateway: gw.Gateway.String(), Subnet: gw.Subnet.String()})
+	}
+	return http.StatusOK, ret, nil
+}
+
+// parseSubnet only accepts network addresses, like the governor.
+func parseSubnet(s string) (*net.IPNet, error) {
+	addr, subnet, err := net.ParseCIDR(s)
+	if err != nil || !addr.Equal(subnet.IP) {
+		return nil, badRequest(errGatewayFormat, s)
+	}
+	return subnet, nil
+}
+
+func (s *Server) createGateway(r *http.Request, account *Account) (int, interface{}, error) {
+	req := &Gateway{}
+	if err := decode(r, req); err != nil {
+		return 0, nil, err
+	}
+	if err := required("Subnet", req.Subnet); err != nil {
+		return 0, nil, err
+	}
+	if err := required("Gateway", req.Gateway); err != nil {
+		return 0, nil, err
+	}
+	subnet, err := parseSubnet(req.Subnet)
+	if err != nil {
+		return 0, nil, err
+	}
+	gw := net.ParseIP(req.Gateway)
+	if gw == nil {
+		return 0, nil, badRequest(errGatewayFormat, req.Gateway)
+	}
+
+	err = s.locked(func() error {
+		gateways, err := s.store.ListGateways()
+		if err != nil {
+			return err
+		}
+		for _, other := range gateways {
+			if other.Subnet.String() == subnet.String() {
+				return badRequest(errSubnetAlreadyExist, subnet.String())
+			}
+		}
+		if !subnet.Contains(gw) {
+			return badRequest(errGatewayNotInSubnet, subnet.String())
+		}
+		return s.store.SetGateway(subnet, gw)
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, &Gateway{Gateway: gw.String(), Subnet: subnet.String()}, nil
+}
+
+func (s *Server) deleteGateway(r *http.Request, account *Account) (int, interface{}, error) {
+	req := &Gateway{}
+	if err := decode(r, req); err != nil {
+		return 0, nil, err
+	}
+	if err := required("Subnet", req.Subnet); err != nil {
+		return 0, nil, err
+	}
+
+	err := s.locked(func() error {
+		gateways, err := s.store.ListGateways()
+		if err != nil {
+			return err
+		}
+		for _, gw := range gateways {
+			if gw.Subnet.String() == req.Subnet {
+				return s.store.DeleteGateway(gw.Subnet)
+			}
+		}
+		return badRequest(errGatewayNotExist, req.Subnet)
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusNoContent, nil, nil
+}
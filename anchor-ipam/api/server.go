@@ -0,0 +1,136 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package api serves the static IP API of the governor on top of
+// backend.Store, with the same resources and request and response shapes,
+// so that the UI and anchor-ipam share one data model.
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+// Server serves the API.
+type Server struct {
+	store backend.Store
+	auth  Authenticator
+}
+
+func NewServer(store backend.Store, auth Authenticator) *Server {
+	return &Server{store: store, auth: auth}
+}
+
+// handler handles a request of an authenticated account, and returns the
+// status and body of the response. A nil body is an empty response.
+type handler func(r *http.Request, account *Account) (int, interface{}, error)
+
+// route dispatches the requests of a path by method.
+type route map[string]handler
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/static_ip", s.serve(route{
+		http.MethodGet: s.listStaticIPs,
+	}))
+	mux.Handle("/api/v1/get_tenant_name", s.serve(route{
+		http.MethodGet: s.listTenantNames,
+	}))
+	mux.Handle("/api/v1/tenant_ip", s.serve(route{
+		http.MethodGet:  s.getTenantIPs,
+		http.MethodPost: admin(s.createTenantIPs),
+	}))
+	mux.Handle("/api/v1/bulk_delete_sip", s.serve(route{
+		http.MethodPost: admin(s.deleteTenantIPs),
+	}))
+	mux.Handle("/api/v1/gateway", s.serve(route{
+		http.MethodGet:    admin(s.listGateways),
+		http.MethodPost:   admin(s.createGateway),
+		http.MethodDelete: admin(s.deleteGateway),
+	}))
+	return mux
+}
+
+func (s *Server) serve(rt route) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, ok := rt[r.Method]
+		if !ok {
+			writeJSON(w, http.StatusMethodNotAllowed, &Error{ID: errBadRequest, Message: "method not allowed"})
+			return
+		}
+
+		account, err := s.auth.Authenticate(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		code, body, err := h(r, account)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if body == nil {
+			w.WriteHeader(code)
+			return
+		}
+		writeJSON(w, code, body)
+	})
+}
+
+// admin restricts a handler to administrators. Like the governor, others
+// get an empty 401.
+func admin(h handler) handler {
+	return func(r *http.Request, account *Account) (int, interface{}, error) {
+		if !account.IsAdmin {
+			return http.StatusUnauthorized, nil, nil
+		}
+		return h(r, account)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	e := apiError(err)
+	if e.Code == http.StatusInternalServerError {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	}
+	writeJSON(w, e.Code, e)
+}
+
+// decode reads the JSON body of a request into v.
+func decode(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return badRequest(errBadRequest, "invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+// required fails like the governor for missing arguments.
+func required(name, value string) error {
+	if value == "" {
+		return badRequest(errBadRequest, "400 Bad Request: Missing required parameter %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// memStore is a backend.Store in memory.
+type memStore struct {
+	backend.Store
+	allocs   []*backend.Allocation
+	pools    map[string]string
+	gateways []*backend.Gateway
+	tenants  map[string]string
+}
+
+func (s *memStore) Lock() error   { return nil }
+func (s *memStore) Unlock() error { return nil }
+
+func (s *memStore) ListAllocations() ([]*backend.Allocation, error) { return s.allocs, nil }
+func (s *memStore) ListPools() (map[string]string, error)           { return s.pools, nil }
+func (s *memStore) ListGateways() ([]*backend.Gateway, error)       { return s.gateways, nil }
+
+func (s *memStore) ListTenants() (map[string]string, error) { return s.tenants, nil }
+
+func (s *memStore) GetUsedIPbyNamespace(namespace string) ([]net.IP, error) {
+	ret := []net.IP{}
+	for _, a := range s.allocs {
+		if a.Owner() == namespace {
+			ret = append(ret, a.IP)
+		}
+	}
+	return ret, nil
+}
+
+func (s *memStore) SetPool(namespace string, ranges string) error {
+	s.pools[namespace] = ranges
+	return nil
+}
+
+func (s *memStore) DeletePool(namespace string) error {
+	delete(s.pools, namespace)
+	return nil
+}
+
+func (s *memStore) SetGateway(subnet *net.IPNet, gateway net.IP) error {
+	s.gateways = append(s.gateways, &backend.Gateway{Subnet: subnet, Gateway: gateway})
+	return nil
+}
+
+func (s *memStore) DeleteGateway(subnet *net.IPNet) error {
+	for i, gw := range s.gateways {
+		if gw.Subnet.String() == subnet.String() {
+			s.gateways = append(s.gateways[:i], s.gateways[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// tenantAuth is a user of tenant "test".
+type tenantAuth struct{}
+
+func (tenantAuth) Authenticate(r *http.Request) (*Account, error) {
+	return &Account{Name: "user"}, nil
+}
+
+func (tenantAuth) Tenants(r *http.Request) ([]string, error) {
+	return []string{"test"}, nil
+}
+
+var _ = Describe("static IP API", func() {
+	var (
+		store  *memStore
+		server *Server
+	)
+
+	BeforeEach(func() {
+		_, subnet, _ := net.ParseCIDR("192.168.4.0/24")
+		store = &memStore{
+			allocs: []*backend.Allocation{
+				{ID: "c1", IP: net.ParseIP("192.168.4.11"), Pod: "web-0", Namespace: "default", App: "shop", Service: "web"},
+				{ID: "c2", IP: net.ParseIP("192.168.4.1"), Pod: "2048", Namespace: "test", App: "game", Service: "2048"},
+			},
+			pools: map[string]string{
+				"default": "192.168.4.[10-19]",
+				"test":    "192.168.4.1,192.168.4.2",
+			},
+			gateways: []*backend.Gateway{{Subnet: subnet, Gateway: net.ParseIP("192.168.4.254")}},
+			tenants:  map[string]string{"ops": "operations, without a pool yet"},
+		}
+		server = NewServer(store, NoAuth{})
+	})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	expectError := func(w *httptest.ResponseRecorder, id string) {
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		e := &Error{}
+		Expect(json.Unmarshal(w.Body.Bytes(), e)).To(Succeed())
+		Expect(e.ID).To(Equal(id))
+	}
+
+	It("lists the allocated addresses by IP", func() {
+		w := do("GET", "/api/v1/static_ip", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		sips := map[string]*StaticIP{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &sips)).To(Succeed())
+		Expect(sips).To(HaveLen(2))
+		Expect(*sips["192.168.4.11"]).To(Equal(StaticIP{
+			AppName:     "shop",
+			ContainerId: "c1",
+			PodName:     "web-0",
+			ServiceName: "web",
+			StaticIp:    "192.168.4.11",
+			TenantName:  "default",
+		}))
+	})
+
+	It("only shows the addresses of their tenants to users", func() {
+		server = NewServer(store, tenantAuth{})
+		w := do("GET", "/api/v1/static_ip", "")
+		Expect(w.Body.String()).NotTo(ContainSubstring("192.168.4.11"))
+		Expect(w.Body.String()).To(ContainSubstring("192.168.4.1"))
+
+		w = do("POST", "/api/v1/gateway", `{"Subnet": "10.0.0.0/24", "Gateway": "10.0.0.1"}`)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("lists the used, all and unused addresses of a tenant", func() {
+		ips := func(query string) []string {
+			w := do("GET", "/api/v1/tenant_ip?TenantName=default"+query, "")
+			Expect(w.Code).To(Equal(http.StatusOK))
+			ret := map[string][]string{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &ret)).To(Succeed())
+			return ret["default"]
+		}
+		Expect(ips("")).To(Equal([]string{"192.168.4.11"}))
+		Expect(ips("&All=1")).To(HaveLen(10))
+		Expect(ips("&Unuse=true")).To(HaveLen(9))
+		Expect(ips("&Unuse=true")).NotTo(ContainElement("192.168.4.11"))
+		Expect(ips("&Unuse=true&Ranges=1")).To(Equal([]string{"192.168.4.10", "192.168.4.[12-19]"}))
+
+		expectError(do("GET", "/api/v1/tenant_ip?TenantName=nobody&All=1", ""), errNotExist)
+	})
+
+	It("adds addresses to the pool of a tenant", func() {
+		w := do("POST", "/api/v1/tenant_ip", `{"StartIp": "192.168.4.3", "EndIp": "192.168.4.8", "TenantName": "test"}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(w.Body.String()).To(ContainSubstring(`"Status": "Ok"`))
+		Expect(store.pools["test"]).To(Equal("192.168.4.[1-8]"))
+
+		w = do("POST", "/api/v1/tenant_ip", `{"StartIp": "192.168.5.1", "EndIp": "192.168.5.2", "TenantName": "new"}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(store.pools["new"]).To(Equal("192.168.5.[1-2]"))
+	})
+
+	It("keeps IPv6 pools as ranges", func() {
+		w := do("POST", "/api/v1/tenant_ip", `{"StartIp": "fd00::1", "EndIp": "fd00::ffff:ffff", "TenantName": "v6"}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(store.pools["v6"]).To(Equal("fd00::1-fd00::ffff:ffff"))
+
+		w = do("POST", "/api/v1/bulk_delete_sip", `{"StaticIps": ["fd00::1"], "TenantName": "v6"}`)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(store.pools["v6"]).To(Equal("fd00::2-fd00::ffff:ffff"))
+
+		expectError(do("GET", "/api/v1/tenant_ip?TenantName=v6&All=1", ""), errRangeTooBig)
+		w = do("GET", "/api/v1/tenant_ip?TenantName=v6&All=1&Ranges=1", "")
+		Expect(w.Body.String()).To(MatchJSON(`{"v6": ["fd00::2-fd00::ffff:ffff"]}`))
+	})
+
+	It("validates the added addresses", func() {
+		for body, id := range map[string]string{
+			`{"StartIp": "192.168.4.", "EndIp": "192.168.4.8", "TenantName": "test"}`:    errFormat,
+			`{"StartIp": "192.168.4.3", "EndIp": "192.168.5.8", "TenantName": "test"}`:   errRangeTooBig,
+			`{"StartIp": "192.168.4.8", "EndIp": "192.168.4.3", "TenantName": "test"}`:   errRange,
+			`{"StartIp": "192.168.4.3", "EndIp": "fd00::8", "TenantName": "test"}`:       errFormat,
+			`{"StartIp": "192.168.4.15", "EndIp": "192.168.4.20", "TenantName": "test"}`: errAlreadyExist,
+			`{"StartIp": "192.168.4.3", "EndIp": "192.168.4.8"}`:                         errBadRequest,
+		} {
+			By(body)
+			expectError(do("POST", "/api/v1/tenant_ip", body), id)
+		}
+	})
+
+	It("removes free addresses from the pool of a tenant", func() {
+		w := do("POST", "/api/v1/bulk_delete_sip", `{"StaticIps": ["192.168.4.12", "192.168.4.13"], "TenantName": "default"}`)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(store.pools["default"]).To(Equal("192.168.4.[10-11],192.168.4.[14-19]"))
+
+		expectError(do("POST", "/api/v1/bulk_delete_sip", `{"StaticIps": ["192.168.4.11"], "TenantName": "default"}`), errAlreadyAssigned)
+		expectError(do("POST", "/api/v1/bulk_delete_sip", `{"StaticIps": ["192.168.4.2"], "TenantName": "default"}`), errNotBelongToTenant)
+		expectError(do("POST", "/api/v1/bulk_delete_sip", `{"StaticIps": ["192.168.4.2"], "TenantName": "nobody"}`), errNotExist)
+
+		w = do("POST", "/api/v1/bulk_delete_sip", `{"StaticIps": ["192.168.4.2"], "TenantName": "test"}`)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(store.pools["test"]).To(Equal("192.168.4.1"))
+	})
+
+	It("manages the gateways", func() {
+		w := do("POST", "/api/v1/gateway", `{"Subnet": "192.168.5.0/24", "Gateway": "192.168.5.1"}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		gw := &Gateway{}
+		Expect(json.Unmarshal(w.Body.Bytes(), gw)).To(Succeed())
+		Expect(*gw).To(Equal(Gateway{Gateway: "192.168.5.1", Subnet: "192.168.5.0/24"}))
+
+		expectError(do("POST", "/api/v1/gateway", `{"Subnet": "192.168.5.0/24", "Gateway": "192.168.5.1"}`), errSubnetAlreadyExist)
+		expectError(do("POST", "/api/v1/gateway", `{"Subnet": "192.168.6.0/24", "Gateway": "192.168.7.1"}`), errGatewayNotInSubnet)
+		expectError(do("POST", "/api/v1/gateway", `{"Subnet": "192.168.6.3/24", "Gateway": "192.168.6.1"}`), errGatewayFormat)
+
+		w = do("GET", "/api/v1/gateway", "")
+		gateways := []*Gateway{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &gateways)).To(Succeed())
+		Expect(gateways).To(HaveLen(2))
+
+		w = do("DELETE", "/api/v1/gateway", `{"Subnet": "192.168.5.0/24"}`)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(store.gateways).To(HaveLen(1))
+		expectError(do("DELETE", "/api/v1/gateway", `{"Subnet": "192.168.5.0/24"}`), errGatewayNotExist)
+	})
+
+	It("lists the tenants", func() {
+		w := do("GET", "/api/v1/get_tenant_name", "")
+		Expect(w.Body.String()).To(MatchJSON(`["default", "ops", "test"]`))
+	})
+
+	It("reports addresses by the tenant owning their pool", func() {
+		store.allocs = append(store.allocs, &backend.Allocation{
+			ID: "c3", IP: net.ParseIP("192.168.4.12"), Pod: "job-0", Namespace: "batch", App: "etl", Service: "job", Tenant: "default",
+		})
+		w := do("GET", "/api/v1/static_ip", "")
+		sips := map[string]*StaticIP{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &sips)).To(Succeed())
+		Expect(sips["192.168.4.12"].TenantName).To(Equal("default"))
+
+		w = do("GET", "/api/v1/tenant_ip?TenantName=default", "")
+		Expect(w.Body.String()).To(MatchJSON(`{"default": ["192.168.4.11", "192.168.4.12"]}`))
+	})
+})
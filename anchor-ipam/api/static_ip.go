@@ -0,0 +1,343 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+)
+
+// StaticIP is an allocated address. Its tenant owns the pool it was
+// allocated from, a namespace bound to no tenant owning its own pool.
+type StaticIP struct {
+	AppName     string
+	ContainerId string
+	PodName     string
+	ServiceName string
+	StaticIp    string
+	TenantName  string
+}
+
+// listStaticIPs returns the allocated addresses by IP, those of the tenants
+// of the account unless it is an administrator.
+func (s *Server) listStaticIPs(r *http.Request, account *Account) (int, interface{}, error) {
+	var tenants map[string]bool
+	if !account.IsAdmin {
+		names, err := s.auth.Tenants(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		if names != nil {
+			tenants = map[string]bool{}
+			for _, name := range names {
+				tenants[name] = true
+			}
+		}
+	}
+
+	allocs, err := s.store.ListAllocations()
+	if err != nil {
+		return 0, nil, err
+	}
+	ret := map[string]*StaticIP{}
+	for _, a := range allocs {
+		if tenants != nil && !tenants[a.Owner()] {
+			continue
+		}
+		ret[a.IP.String()] = &StaticIP{
+			AppName:     a.App,
+			ContainerId: a.ID,
+			PodName:     a.Pod,
+			ServiceName: a.Service,
+			StaticIp:    a.IP.String(),
+			TenantName:  a.Owner(),
+		}
+	}
+	return http.StatusOK, ret, nil
+}
+
+// listTenantNames returns the tenants of the account, or the tenants and the
+// namespaces with a pool when the authenticator doesn't restrict tenants.
+func (s *Server) listTenantNames(r *http.Request, account *Account) (int, interface{}, error) {
+	names, err := s.auth.Tenants(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if names == nil {
+		pools, err := s.store.ListPools()
+		if err != nil {
+			return 0, nil, err
+		}
+		tenants, err := s.store.ListTenants()
+		if err != nil {
+			return 0, nil, err
+		}
+		names = []string{}
+		for name := range pools {
+			names = append(names, name)
+		}
+		for name := range tenants {
+			if _, ok := pools[name]; !ok {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return http.StatusOK, names, nil
+}
+
+// truthy parses the boolean arguments the way the governor does.
+func truthy(s string) bool {
+	switch strings.ToLower(s) {
+	case "yes", "true", "t", "1":
+		return true
+	}
+	return false
+}
+
+// maxListedIPs caps the addresses getTenantIPs lists one by one, as a single
+// IPv6 pool holds more than any response can.
+const maxListedIPs = 65536
+
+// getTenantIPs returns the used addresses of a tenant, or all the addresses
+// of its pool with All, or the unused ones with Unuse. With Ranges, those are
+// returned as the ranges of the pool grammar instead of one by one, which
+// larger pools require.
+func (s *Server) getTenantIPs(r *http.Request, account *Account) (int, interface{}, error) {
+	query := r.URL.Query()
+	tenant := query.Get("TenantName")
+	if err := required("TenantName", tenant); err != nil {
+		return 0, nil, err
+	}
+	all, unuse := truthy(query.Get("All")), truthy(query.Get("Unuse"))
+	ranges := truthy(query.Get("Ranges"))
+
+	used, err := s.store.GetUsedIPbyNamespace(tenant)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !all && !unuse {
+		return http.StatusOK, map[string][]string{tenant: ipStrings(used)}, nil
+	}
+
+	pools, err := s.store.ListPools()
+	if err != nil {
+		return 0, nil, err
+	}
+	pool, ok := pools[tenant]
+	if !ok {
+		return 0, nil, badRequest(errNotExist, tenant)
+	}
+	rs, err := allocator.LoadRangeSet(pool)
+	if err != nil {
+		return 0, nil, err
+	}
+	if unuse {
+		rs = rs.Subtract(allocator.RangeSetOf(used))
+	}
+	if ranges {
+		return http.StatusOK, map[string][]string{tenant: rangeStrings(rs)}, nil
+	}
+	if rs.Size().Cmp(big.NewInt(maxListedIPs)) > 0 {
+		return 0, nil, badRequest(errRangeTooBig, "%s has more than %d addresses, list them with Ranges", tenant, maxListedIPs)
+	}
+	return http.StatusOK, map[string][]string{tenant: ipStrings(setIPs(rs))}, nil
+}
+
+type createTenantIPsRequest struct {
+	StartIp    string
+	EndIp      string
+	TenantName string
+}
+
+// createTenantIPs adds the addresses from StartIp to EndIp to the pool of a
+// tenant. IPv4 ranges stay in the same /24, as with the governor.
+func (s *Server) createTenantIPs(r *http.Request, account *Account) (int, interface{}, error) {
+	req := &createTenantIPsRequest{}
+	if err := decode(r, req); err != nil {
+		return 0, nil, err
+	}
+	for name, value := range map[string]string{"StartIp": req.StartIp, "EndIp": req.EndIp, "TenantName": req.TenantName} {
+		if err := required(name, value); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	start, end := net.ParseIP(req.StartIp), net.ParseIP(req.EndIp)
+	if start == nil || end == nil || (start.To4() == nil) != (end.To4() == nil) {
+		return 0, nil, badRequest(errFormat, "")
+	}
+	if start4, end4 := start.To4(), end.To4(); start4 != nil && !bytes.Equal(start4[:3], end4[:3]) {
+		return 0, nil, badRequest(errRangeTooBig, "")
+	}
+	if bytes.Compare(start.To16(), end.To16()) > 0 {
+		return 0, nil, badRequest(errRange, "")
+	}
+	added := start.String() + "-" + end.String()
+
+	err := s.locked(func() error {
+		rs, err := allocator.LoadRangeSet(added)
+		if err != nil {
+			return badRequest(errFormat, "%v", err)
+		}
+		pools, err := s.store.ListPools()
+		if err != nil {
+			return err
+		}
+		for ns, pool := range pools {
+			other, err := allocator.LoadRangeSet(pool)
+			if err != nil {
+				continue
+			}
+			if r := rs.FirstOverlap(other); r != nil {
+				return badRequest(errAlreadyExist, "%s is already in the pool of %s", r.String(), ns)
+			}
+		}
+
+		pool := added
+		if existing, ok := pools[req.TenantName]; ok {
+			pool = existing + "," + added
+		}
+		return s.setPool(req.TenantName, pool)
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, map[string]string{"Status": "Ok"}, nil
+}
+
+type deleteTenantIPsRequest struct {
+	StaticIps  []string
+	TenantName string
+}
+
+// deleteTenantIPs removes addresses from the pool of a tenant. Unlike the
+// governor, it refuses to remove allocated ones.
+func (s *Server) deleteTenantIPs(r *http.Request, account *Account) (int, interface{}, error) {
+	req := &deleteTenantIPsRequest{}
+	if err := decode(r, req); err != nil {
+		return 0, nil, err
+	}
+	if err := required("TenantName", req.TenantName); err != nil {
+		return 0, nil, err
+	}
+	if len(req.StaticIps) == 0 {
+		return 0, nil, required("StaticIps", "")
+	}
+	addrs := make([]net.IP, 0, len(req.StaticIps))
+	for _, str := range req.StaticIps {
+		addr := net.ParseIP(str)
+		if addr == nil {
+			return 0, nil, badRequest(errFormat, str)
+		}
+		addrs = append(addrs, addr)
+	}
+	removed := allocator.RangeSetOf(addrs)
+
+	err := s.locked(func() error {
+		pools, err := s.store.ListPools()
+		if err != nil {
+			return err
+		}
+		pool, ok := pools[req.TenantName]
+		if !ok {
+			return badRequest(errNotExist, req.TenantName)
+		}
+		rs, err := allocator.LoadRangeSet(pool)
+		if err != nil {
+			return err
+		}
+		if len(*rs.Intersect(removed)) == 0 {
+			return badRequest(errNotBelongToTenant, req.TenantName)
+		}
+
+		used, err := s.store.GetUsedIPbyNamespace(req.TenantName)
+		if err != nil {
+			return err
+		}
+		if allocated := allocator.RangeSetOf(used).Intersect(removed); len(*allocated) > 0 {
+			return badRequest(errAlreadyAssigned, "%s is allocated", (*allocated)[0].RangeStart)
+		}
+
+		left := rs.Subtract(removed)
+		if len(*left) == 0 {
+			return s.store.DeletePool(req.TenantName)
+		}
+		formatted, err := allocator.FormatRangeSet(left)
+		if err != nil {
+			return badRequest(errFormat, "%v", err)
+		}
+		return s.store.SetPool(req.TenantName, formatted)
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusNoContent, nil, nil
+}
+
+// setPool stores a pool in its shortest form.
+func (s *Server) setPool(namespace, pool string) error {
+	rs, err := allocator.LoadRangeSet(pool)
+	if err != nil {
+		return badRequest(errFormat, "%v", err)
+	}
+	formatted, err := allocator.FormatRangeSet(rs)
+	if err != nil {
+		return badRequest(errFormat, "%v", err)
+	}
+	return s.store.SetPool(namespace, formatted)
+}
+
+// locked runs f with the store locked, so it doesn't race with allocations.
+func (s *Server) locked(f func() error) error {
+	if err := s.store.Lock(); err != nil {
+		return err
+	}
+	defer s.store.Unlock()
+	return f()
+}
+
+// setIPs returns every address of a set.
+func setIPs(rs *allocator.RangeSet) []net.IP {
+	ret := []net.IP{}
+	it := rs.FreeIPs(&allocator.RangeSet{})
+	for addr := it.Next(); addr != nil; addr = it.Next() {
+		ret = append(ret, addr)
+	}
+	return ret
+}
+
+// rangeStrings returns the ranges of a set, in the pool grammar.
+func rangeStrings(rs *allocator.RangeSet) []string {
+	if len(*rs) == 0 {
+		return []string{}
+	}
+	formatted, _ := allocator.FormatRangeSet(rs)
+	return strings.Split(formatted, ",")
+}
+
+func ipStrings(ips []net.IP) []string {
+	ret := make([]string, 0, len(ips))
+	for _, addr := range ips {
+		ret = append(ret, addr.String())
+	}
+	return ret
+}
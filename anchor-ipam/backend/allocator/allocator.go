@@ -38,9 +38,11 @@ type AnchorAllocator struct {
 	podNamespace string
 	app          string
 	service      string
+	// The tenant the pod asks for, see ResolveTenant.
+	currentUser string
 }
 
-func NewAnchorAllocator(subnet *net.IPNet, store backend.Store, podName string, podNamespace string, app string, service string) *AnchorAllocator {
+func NewAnchorAllocator(subnet *net.IPNet, store backend.Store, podName string, podNamespace string, app string, service string, currentUser string) *AnchorAllocator {
 	return &AnchorAllocator{
 		subnet:       subnet,
 		store:        store,
@@ -48,6 +50,7 @@ func NewAnchorAllocator(subnet *net.IPNet, store backend.Store, podName string,
 		podNamespace: podNamespace,
 		app:          app,
 		service:      service,
+		currentUser:  currentUser,
 	}
 }
 
@@ -64,63 +67,151 @@ func (a *AnchorAllocator) Get(id string) (*current.IPConfig, error) {
 	defer a.store.Unlock()
 	var errors []string
 
-	availsForNamespace, err := a.store.GetAllocatedIPs(a.podNamespace)
+	tenants, err := a.store.ListTenants()
 	if err != nil {
 		errors = append(errors, err.Error())
 		return nil, fmt.Errorf(strings.Join(errors, ";"))
 	}
+	bindings, err := a.store.ListTenantBindings()
+	if err != nil {
+		errors = append(errors, err.Error())
+		return nil, fmt.Errorf(strings.Join(errors, ";"))
+	}
+	tenant, err := ResolveTenant(tenants, bindings, a.podNamespace, a.currentUser)
+	if err != nil {
+		return nil, err
+	}
+
+	availsForNamespace, err := a.store.GetAllocatedIPs(tenant)
+	if _, ok := err.(backend.ErrNotFound); ok {
+		return nil, allocError(ReasonNoPool, fmt.Sprintf("%s has no IP pool", describePool(tenant, a.podNamespace)))
+	}
+	if err != nil {
+		errors = append(errors, err.Error())
+		return nil, fmt.Errorf(strings.Join(errors, ";"))
+	}
+	quota, err := a.store.GetQuota(tenant)
+	if _, ok := err.(backend.ErrNotFound); !ok {
+		if err != nil {
+			errors = append(errors, err.Error())
+			return nil, fmt.Errorf(strings.Join(errors, ";"))
+		}
+		used, err := a.store.GetUsedIPbyNamespace(tenant)
+		if err != nil {
+			errors = append(errors, err.Error())
+			return nil, fmt.Errorf(strings.Join(errors, ";"))
+		}
+		if len(used) >= quota {
+			return nil, allocError(ReasonQuotaExceeded, fmt.Sprintf("%s reached its quota of %d IPs", describePool(tenant, a.podNamespace), quota))
+		}
+	}
 	availsRangeSet, err := LoadRangeSetInSubnet(availsForNamespace, a.subnet)
 	if err != nil {
 		errors = append(errors, err.Error())
 		return nil, fmt.Errorf(strings.Join(errors, ";"))
 	}
-	// TODO: reduce usedByNamespace by subnet to save the time in compare stage.
-	usedByNamespace, err := a.store.GetUsedIPbyNamespace(a.podNamespace)
+	if len(*availsRangeSet) == 0 {
+		return nil, allocError(ReasonSubnetNotInPool, fmt.Sprintf("the pool of %s has no IP in subnet %s", describePool(tenant, a.podNamespace), a.subnet))
+	}
+	// The allocations of the subnet are looked up in its index, which is
+	// kept by the subnet registered with the gateway.
+	registered, _, err := a.store.GetGatewayForIP(a.subnet.IP)
+	if _, ok := err.(backend.ErrNotFound); ok {
+		return nil, allocError(ReasonGatewayMissing, fmt.Sprintf("no gateway is registered for subnet %s", a.subnet))
+	}
+	if err != nil {
+		errors = append(errors, err.Error())
+		return nil, fmt.Errorf(strings.Join(errors, ";"))
+	}
+	index, err := a.store.GetIndex(registered)
+	if err != nil {
+		errors = append(errors, err.Error())
+		return nil, fmt.Errorf(strings.Join(errors, ";"))
+	}
+
+	// Reserved by ADDs not completed yet, which aren't indexed, held, or
+	// found in use by hosts anchor doesn't know about.
+	unavail, err := a.store.GetUnavailable(registered)
+	if err != nil {
+		errors = append(errors, err.Error())
+		return nil, fmt.Errorf(strings.Join(errors, ";"))
+	}
+
+	stored, err := a.store.ListExclusions()
+	if err != nil {
+		errors = append(errors, err.Error())
+		return nil, fmt.Errorf(strings.Join(errors, ";"))
+	}
+	exclusions, err := LoadExclusions(stored)
 	if err != nil {
 		errors = append(errors, err.Error())
 		return nil, fmt.Errorf(strings.Join(errors, ";"))
 	}
 
-	for _, r := range *availsRangeSet {
-		var iter net.IP
-		for iter = r.RangeStart; !iter.Equal(ip.NextIP(r.RangeEnd)); iter = ip.NextIP(iter) {
-			avail := true
-			for _, used := range usedByNamespace {
-				if iter.Equal(used) {
-					avail = false
-					break
-				}
+	// Everything that can't be handed out besides the allocations, so that
+	// the free addresses are found with interval arithmetic, and checked
+	// against the index one by one.
+	used := append(append([]net.IP{}, unavail.External...), unavail.Pending...)
+	// Addresses held for the workload of the pod are tried first, those
+	// held for others aren't allocated.
+	held := []net.IP{}
+	for _, h := range unavail.Holds {
+		if h.Namespace == a.podNamespace && h.Service == a.service && a.service != backend.UnknownService {
+			held = append(held, h.IP)
+		} else {
+			used = append(used, h.IP)
+		}
+	}
+	unavailable := RangeSetOf(used).Union(exclusions.set(a.subnet, tenant))
+
+	gatewayMissing := false
+	candidates := []*FreeIter{
+		RangeSetOf(held).Intersect(availsRangeSet).FreeIPs(unavailable),
+		availsRangeSet.FreeIPs(unavailable),
+	}
+	for _, free := range candidates {
+		for iter := free.Next(); iter != nil; iter = free.Next() {
+			if index.Has(iter) {
+				continue
+			}
+			// Get subnet and gateway information
+			subnet, gw, err := a.store.GetGatewayForIP(iter)
+			if _, ok := err.(backend.ErrNotFound); ok {
+				gatewayMissing = true
+			}
+			if err != nil {
+				errors = append(errors, err.Error())
+				continue
 			}
-			if avail {
-				// Get subnet and gateway information
-				subnet, gw, err := a.store.GetGatewayForIP(iter)
-				if err != nil {
-					errors = append(errors, err.Error())
-					continue
-				}
-
-				if iter.Equal(*gw) {
-					errors = append(errors, "Can not lookup gateway for IP")
-					continue
-
-				}
-				_, err = a.store.Reserve(id, iter, a.podName, a.podNamespace, a.app, a.service)
-				if err != nil {
-					// TODO: log
-					errors = append(errors, "Cannot write allocated IP to database")
-					continue
-				}
-
-				return &current.IPConfig{
-					Version: "4",
-					Address: net.IPNet{IP: iter, Mask: subnet.Mask},
-					Gateway: *gw,
-				}, nil
+
+			if iter.Equal(*gw) {
+				errors = append(errors, "Can not lookup gateway for IP")
+				continue
+
+			}
+			_, err = a.store.Reserve(id, iter, a.podName, a.podNamespace, a.app, a.service, tenant)
+			if err != nil {
+				// TODO: log
+				errors = append(errors, "Cannot write allocated IP to database")
+				continue
 			}
+
+			version := "4"
+			if iter.To4() == nil {
+				version = "6"
+			}
+			return &current.IPConfig{
+				Version: version,
+				Address: net.IPNet{IP: iter, Mask: subnet.Mask},
+				Gateway: *gw,
+			}, nil
 		}
 	}
+	if gatewayMissing {
+		return nil, allocError(ReasonGatewayMissing, strings.Join(errors, ";"))
+	}
 	errors = append(errors, "Error when allocate IP for Pod, Maybe no IP available")
-	return nil, fmt.Errorf(strings.Join(errors, ";"))
+	return nil, allocError(ReasonPoolExhausted, strings.Join(errors, ";"))
 }
 
 // Release clears all IPs allocated for the container with given ID
@@ -20,6 +20,7 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/daocloud/anchor/anchor-ipam/k8s"
 	"net"
+	"strings"
 )
 
 // The top-level network config, just so we can get the IPAM block
@@ -29,6 +30,7 @@ type Net struct {
 	Type       string      `json:"type"`
 	Master     string      `json:"master"`
 	IPAM       *IPAMConfig `json:"ipam"`
+	DNS        types.DNS   `json:"dns"`
 	// TODO:
 	// LogLevel       string         `json:"log_level"`
 }
@@ -38,21 +40,43 @@ type Net struct {
 // range directly, and wish to preserve backwards compatability
 type IPAMConfig struct {
 	Name string
-	Type string                  `json:"type"`
+	Type string `json:"type"`
 	// etcd client
-	Endpoints     string         `json:"etcd_endpoints"`
+	Endpoints string `json:"etcd_endpoints"`
+	// Prefix of the etcd keys, see EtcdPrefix.
+	EtcdKeyPrefix string `json:"etcd_prefix"`
+	// Key the network under /<name>/ rather than /anchor/ when
+	// etcd_prefix is unset.
+	EtcdPrefixByName bool `json:"etcd_prefix_by_name"`
 	// Used for k8s client
-	Kubernetes    k8s.Kubernetes `json:"kubernetes"`
-	Policy        k8s.Policy     `json:"policy"`
+	Kubernetes k8s.Kubernetes `json:"kubernetes"`
+	Policy     k8s.Policy     `json:"policy"`
+	// Also default the pod annotations to those of its owning workload,
+	// the namespace is always used.
+	WorkloadDefaults bool `json:"workload_defaults"`
+	// Unix socket of the node-local anchor agent, the plugin talks to
+	// etcd and Kubernetes itself when no agent listens there.
+	AgentSocket string `json:"agent_socket"`
 	// etcd perm files
-	CertFile      string         `json:"etcd_cert_file"`
-	KeyFile       string         `json:"etcd_key_file"`
-	TrustedCAFile string         `json:"etcd_ca_cert_file"`
-	Service_IPNet string         `json:"service_ipnet"`
-	Node_IPs      []string       `json:"node_ips"`
+	CertFile      string   `json:"etcd_cert_file"`
+	KeyFile       string   `json:"etcd_key_file"`
+	TrustedCAFile string   `json:"etcd_ca_cert_file"`
+	Service_IPNet string   `json:"service_ipnet"`
+	Node_IPs      []string `json:"node_ips"`
 	// additional network config for pods
-	Routes        []*types.Route `json:"routes,omitempty"`
-	ResolvConf    string         `json:"resolvConf,omitempty"`
+	Routes     []*types.Route `json:"routes,omitempty"`
+	ResolvConf string         `json:"resolvConf,omitempty"`
+	// DNSPolicy orders the sources of the pod DNS settings by precedence,
+	// among "annotations", "network" and "resolvConf".
+	DNSPolicy []string `json:"dns_policy,omitempty"`
+	// NetworkDNS is the "dns" of the network config.
+	NetworkDNS types.DNS `json:"-"`
+	// How long the history of a released address is kept, 30 days
+	// when 0.
+	HistoryRetentionHours int `json:"history_retention_hours"`
+	// Commit the reservations at the end of the ADD of anchor-ipam, for
+	// main plugins other than octopus, which don't confirm the ADD.
+	CommitOnAdd bool `json:"commit_on_add"`
 
 	// Args       *struct {
 	//       A *IPAMArgs `json:"cni"`
@@ -132,5 +156,30 @@ func LoadIPAMConfig(bytes []byte, envArgs string) (*IPAMConfig, string, error) {
 		// Copy net name into IPAM so not to drag Net struct around
 		n.IPAM.Name = n.Name
 	*/
+	n.IPAM.NetworkDNS = n.DNS
+	// Copy net name into IPAM so not to drag Net struct around
+	n.IPAM.Name = n.Name
 	return n.IPAM, n.CNIVersion, nil
 }
+
+// DefaultEtcdPrefix is the etcd key prefix of the networks without
+// etcd_prefix, where every network was keyed before prefixes existed.
+const DefaultEtcdPrefix = "/anchor/"
+
+// EtcdPrefix returns the prefix of the etcd keys of the network: etcd_prefix
+// when set, /<network name>/ with etcd_prefix_by_name, DefaultEtcdPrefix
+// otherwise. Networks sharing an etcd with different prefixes don't see
+// each other's keys. It always ends with a slash.
+func (c *IPAMConfig) EtcdPrefix() string {
+	prefix := c.EtcdKeyPrefix
+	if prefix == "" && c.EtcdPrefixByName && c.Name != "" {
+		prefix = "/" + c.Name + "/"
+	}
+	if prefix == "" {
+		return DefaultEtcdPrefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
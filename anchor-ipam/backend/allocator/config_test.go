@@ -362,4 +362,20 @@ var _ = Describe("IPAM config", func() {
 		_, _, err := LoadIPAMConfig([]byte(input), "")
 		Expect(err).NotTo(HaveOccurred())
 	})
+
+	It("keys each network under its own etcd prefix", func() {
+		conf, _, err := LoadIPAMConfig([]byte(`{"name": "blue", "ipam": {"etcd_endpoints": "127.0.0.1:2379"}}`), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.EtcdPrefix()).To(Equal(DefaultEtcdPrefix))
+
+		conf, _, err = LoadIPAMConfig([]byte(`{"name": "blue", "ipam": {"etcd_endpoints": "127.0.0.1:2379", "etcd_prefix_by_name": true}}`), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.EtcdPrefix()).To(Equal("/blue/"))
+
+		conf, _, err = LoadIPAMConfig([]byte(`{"name": "blue", "ipam": {"etcd_endpoints": "127.0.0.1:2379", "etcd_prefix": "/cluster-2/anchor"}}`), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.EtcdPrefix()).To(Equal("/cluster-2/anchor/"))
+
+		Expect((&IPAMConfig{}).EtcdPrefix()).To(Equal(DefaultEtcdPrefix))
+	})
 })
@@ -0,0 +1,56 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+// Reasons an allocation fails for, also used as the reasons of the
+// Kubernetes events about it.
+const (
+	// Every address of the pool of the namespace in the subnet is used.
+	ReasonPoolExhausted = "PoolExhausted"
+	// The pool of the namespace has no address in the subnet of the pod.
+	ReasonSubnetNotInPool = "SubnetNotInPool"
+	// No gateway is registered for the subnet of the free addresses.
+	ReasonGatewayMissing = "GatewayMissing"
+	// The namespace has no pool, so no address may be allocated to it.
+	ReasonNoPool = "NoPool"
+	// The pool of the namespace, or of its tenant, has as many addresses
+	// allocated or reserved as its quota.
+	ReasonQuotaExceeded = "QuotaExceeded"
+	// The pod asks for the pool of a tenant its namespace isn't bound to.
+	ReasonTenantDenied = "TenantDenied"
+)
+
+// AllocError is an allocation failure with a known reason.
+type AllocError struct {
+	Reason  string
+	Message string
+}
+
+func (e *AllocError) Error() string {
+	return e.Reason + ": " + e.Message
+}
+
+func allocError(reason string, message string) *AllocError {
+	return &AllocError{Reason: reason, Message: message}
+}
+
+// ErrorReason returns the reason of an allocation failure, empty when it has
+// no known reason.
+func ErrorReason(err error) string {
+	if allocErr, ok := err.(*AllocError); ok {
+		return allocErr.Reason
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+// Exclusions are the addresses of the pools that are never allocated.
+type Exclusions struct {
+	subnets map[string]RangeSet
+	pools   map[string]RangeSet
+}
+
+// LoadExclusions parses the exclusions kept in the store. With nil, only the
+// network and broadcast addresses are excluded.
+func LoadExclusions(e *backend.Exclusions) (*Exclusions, error) {
+	ret := &Exclusions{subnets: map[string]RangeSet{}, pools: map[string]RangeSet{}}
+	if e == nil {
+		return ret, nil
+	}
+	for subnet, ranges := range e.Subnets {
+		rs, err := LoadRangeSet(ranges)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclusions of subnet %s: %v", subnet, err)
+		}
+		ret.subnets[subnet] = *rs
+	}
+	for ns, ranges := range e.Pools {
+		rs, err := LoadRangeSet(ranges)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclusions of the pool of namespace %s: %v", ns, err)
+		}
+		ret.pools[ns] = *rs
+	}
+	return ret, nil
+}
+
+// Excluded tells whether addr, in subnet, may not be allocated to namespace.
+func (e *Exclusions) Excluded(addr net.IP, subnet *net.IPNet, namespace string) bool {
+	return e.set(subnet, namespace).Includes(addr)
+}
+
+// set returns the excluded addresses of the pool of namespace in subnet. A
+// nil Exclusions only excludes the network and broadcast addresses.
+func (e *Exclusions) set(subnet *net.IPNet, namespace string) *RangeSet {
+	ret := *RangeSetOf(reservedIPs(subnet))
+	if e != nil {
+		// The store may keep a subnet with the host bits of its gateway.
+		network := &net.IPNet{IP: subnet.IP.Mask(subnet.Mask), Mask: subnet.Mask}
+		ret = append(ret, e.subnets[network.String()]...)
+		ret = append(ret, e.pools[namespace]...)
+	}
+	merged := RangeSet(mergeRanges(ret))
+	return &merged
+}
+
+// reservedIPs are the network and broadcast addresses of subnet, which hosts
+// can't use except in /31 and /32 subnets (RFC 3021). IPv6 has no broadcast,
+// only its subnet-router anycast address is reserved.
+func reservedIPs(subnet *net.IPNet) []net.IP {
+	ones, bits := subnet.Mask.Size()
+	if bits-ones < 2 {
+		return nil
+	}
+	network := subnet.IP.Mask(subnet.Mask)
+	if bits == 8*net.IPv6len {
+		return []net.IP{network}
+	}
+	broadcast := make(net.IP, len(network))
+	for i := range network {
+		broadcast[i] = network[i] | ^subnet.Mask[i]
+	}
+	return []net.IP{network, broadcast}
+}
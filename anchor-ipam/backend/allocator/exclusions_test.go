@@ -0,0 +1,65 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"net"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("exclusions", func() {
+	subnet := func(s string) *net.IPNet {
+		_, n, err := net.ParseCIDR(s)
+		Expect(err).NotTo(HaveOccurred())
+		return n
+	}
+
+	It("excludes the network and broadcast addresses", func() {
+		var e *Exclusions
+		Expect(e.Excluded(net.ParseIP("10.0.1.0"), subnet("10.0.1.0/24"), "team-a")).To(BeTrue())
+		Expect(e.Excluded(net.ParseIP("10.0.1.255"), subnet("10.0.1.0/24"), "team-a")).To(BeTrue())
+		Expect(e.Excluded(net.ParseIP("10.0.1.1"), subnet("10.0.1.0/24"), "team-a")).To(BeFalse())
+		Expect(e.Excluded(net.ParseIP("2001:db8::"), subnet("2001:db8::/64"), "team-a")).To(BeTrue())
+	})
+
+	It("keeps every address of /31 and /32 subnets", func() {
+		var e *Exclusions
+		Expect(e.Excluded(net.ParseIP("10.0.1.0"), subnet("10.0.1.0/31"), "team-a")).To(BeFalse())
+		Expect(e.Excluded(net.ParseIP("10.0.1.1"), subnet("10.0.1.0/31"), "team-a")).To(BeFalse())
+		Expect(e.Excluded(net.ParseIP("10.0.1.7"), subnet("10.0.1.7/32"), "team-a")).To(BeFalse())
+	})
+
+	It("excludes the ranges of the subnet and of the pool", func() {
+		e, err := LoadExclusions(&backend.Exclusions{
+			Subnets: map[string]string{"10.0.1.0/24": "10.0.1.[250-254]"},
+			Pools:   map[string]string{"team-a": "10.0.1.10"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(e.Excluded(net.ParseIP("10.0.1.252"), subnet("10.0.1.0/24"), "team-b")).To(BeTrue())
+		Expect(e.Excluded(net.ParseIP("10.0.1.10"), subnet("10.0.1.0/24"), "team-a")).To(BeTrue())
+		Expect(e.Excluded(net.ParseIP("10.0.1.10"), subnet("10.0.1.0/24"), "team-b")).To(BeFalse())
+		Expect(e.Excluded(net.ParseIP("10.0.2.252"), subnet("10.0.2.0/24"), "team-b")).To(BeFalse())
+	})
+
+	It("refuses invalid ranges", func() {
+		_, err := LoadExclusions(&backend.Exclusions{Pools: map[string]string{"team-a": "10.0.1.x"}})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,155 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"net"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// memStore is a backend.Store in memory, with what Get reads.
+type memStore struct {
+	backend.Store
+	pools    map[string]string
+	gateway  *backend.Gateway
+	holds    []*backend.Hold
+	reserved []*backend.Allocation
+	quotas   map[string]int
+}
+
+func (s *memStore) Lock() error   { return nil }
+func (s *memStore) Unlock() error { return nil }
+
+func (s *memStore) ListTenants() (map[string]string, error)        { return nil, nil }
+func (s *memStore) ListTenantBindings() (map[string]string, error) { return nil, nil }
+func (s *memStore) ListExclusions() (*backend.Exclusions, error)   { return nil, nil }
+
+func (s *memStore) GetUnavailable(subnet *net.IPNet) (*backend.Unavailable, error) {
+	return &backend.Unavailable{Holds: s.holds}, nil
+}
+
+func (s *memStore) GetQuota(owner string) (int, error) {
+	quota, ok := s.quotas[owner]
+	if !ok {
+		return 0, backend.ErrNotFound("no quota")
+	}
+	return quota, nil
+}
+
+func (s *memStore) GetUsedIPbyNamespace(namespace string) ([]net.IP, error) {
+	used := []net.IP{}
+	for _, a := range s.reserved {
+		if a.Owner() == namespace {
+			used = append(used, a.IP)
+		}
+	}
+	return used, nil
+}
+
+func (s *memStore) GetAllocatedIPs(namespace string) (string, error) {
+	pool, ok := s.pools[namespace]
+	if !ok {
+		return "", backend.ErrNotFound("no pool")
+	}
+	return pool, nil
+}
+
+func (s *memStore) GetGatewayForIP(ip net.IP) (*net.IPNet, *net.IP, error) {
+	return s.gateway.Subnet, &s.gateway.Gateway, nil
+}
+
+// GetIndex marks the reserved addresses allocated.
+func (s *memStore) GetIndex(subnet *net.IPNet) (*backend.IPBitmap, error) {
+	index := backend.NewIPBitmap(subnet)
+	for _, a := range s.reserved {
+		index.Set(a.IP, true)
+	}
+	return index, nil
+}
+
+func (s *memStore) Reserve(id string, ip net.IP, podName string, podNamespace string, app string, service string, tenant string) (bool, error) {
+	s.reserved = append(s.reserved, &backend.Allocation{ID: id, IP: ip, Pod: podName, Namespace: podNamespace, App: app, Service: service, Tenant: tenant})
+	return true, nil
+}
+
+var _ = Describe("holds", func() {
+	var (
+		store  *memStore
+		subnet *net.IPNet
+	)
+
+	BeforeEach(func() {
+		_, subnet, _ = net.ParseCIDR("10.0.1.0/24")
+		store = &memStore{
+			pools:   map[string]string{"team-a": "10.0.1.[10-12]"},
+			gateway: &backend.Gateway{Subnet: subnet, Gateway: net.ParseIP("10.0.1.1")},
+		}
+	})
+
+	allocate := func(service string) (net.IP, error) {
+		ipConf, err := NewAnchorAllocator(subnet, store, "pod", "team-a", "shop", service, "").Get("c-" + service)
+		if err != nil {
+			return nil, err
+		}
+		return ipConf.Address.IP, nil
+	}
+
+	It("gives the held addresses to their workload first", func() {
+		store.holds = []*backend.Hold{{IP: net.ParseIP("10.0.1.12"), Namespace: "team-a", Service: "web"}}
+
+		ip, err := allocate("web")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ip.String()).To(Equal("10.0.1.12"))
+	})
+
+	It("never gives the held addresses to other workloads", func() {
+		store.holds = []*backend.Hold{
+			{IP: net.ParseIP("10.0.1.10"), Namespace: "team-a", Service: "web"},
+			{IP: net.ParseIP("10.0.1.12"), Namespace: "team-b", Service: "db"},
+		}
+
+		ip, err := allocate("db")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ip.String()).To(Equal("10.0.1.11"))
+
+		_, err = allocate("db")
+		Expect(ErrorReason(err)).To(Equal(ReasonPoolExhausted))
+	})
+
+	It("allocates no more addresses than the quota of the pool", func() {
+		store.quotas = map[string]int{"team-a": 2}
+
+		_, err := allocate("web")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = allocate("db")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = allocate("cache")
+		Expect(ErrorReason(err)).To(Equal(ReasonQuotaExceeded))
+		Expect(store.reserved).To(HaveLen(2))
+	})
+
+	It("gives no held address to the pods without controller", func() {
+		store.holds = []*backend.Hold{{IP: net.ParseIP("10.0.1.10"), Namespace: "team-a", Service: backend.UnknownService}}
+
+		ip, err := allocate(backend.UnknownService)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ip.String()).To(Equal("10.0.1.11"))
+	})
+})
@@ -0,0 +1,290 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Most ranges a bracket token may expand to, such as 10.[0-255].[0-255].1.
+const maxBracketRanges = 1 << 16
+
+// RangeError is a token of a range list that can't be parsed.
+type RangeError struct {
+	Token string
+	// Position of the token in the list, counting from 1.
+	Pos int
+	Msg string
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("invalid range %q at position %d: %s", e.Token, e.Pos, e.Msg)
+}
+
+// parseRanges parses a comma separated list of ranges, each of which is one
+// of:
+//
+//	10.0.1.5                   a single address
+//	10.0.1.0/24                a CIDR block, network and broadcast included
+//	10.0.1.250-10.0.2.10       every address from start to end
+//	10.0.[1-2].[10-19]         every combination of the bracketed bytes
+//	2001:db8::1-2001:db8::ff   IPv6, except the bracket form
+//
+// A range prefixed with "!" is removed from the others, whatever its place in
+// the list. The result is sorted and merged.
+func parseRanges(s string) ([]Range, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("Input of IP ranges is empty")
+	}
+
+	included, excluded := []Range{}, []Range{}
+	pos := 1
+	for _, tok := range strings.Split(s, ",") {
+		trimmed := strings.TrimSpace(tok)
+		tokPos := pos + strings.Index(tok, trimmed)
+		pos += len(tok) + 1
+
+		body, exclude := trimmed, strings.HasPrefix(trimmed, "!")
+		if exclude {
+			body = strings.TrimSpace(strings.TrimPrefix(trimmed, "!"))
+		}
+		ranges, msg := parseRange(body)
+		if msg != "" {
+			return nil, &RangeError{Token: trimmed, Pos: tokPos, Msg: msg}
+		}
+		if exclude {
+			excluded = append(excluded, ranges...)
+		} else {
+			included = append(included, ranges...)
+		}
+	}
+	return subtractRanges(mergeRanges(included), mergeRanges(excluded)), nil
+}
+
+// parseRange parses a single range, returning why it is invalid otherwise.
+func parseRange(tok string) ([]Range, string) {
+	switch {
+	case tok == "":
+		return nil, "empty range"
+	case strings.Contains(tok, "/"):
+		_, subnet, err := net.ParseCIDR(tok)
+		if err != nil {
+			return nil, "invalid CIDR block"
+		}
+		start := subnet.IP
+		end := make(net.IP, len(start))
+		for i := range start {
+			end[i] = start[i] | ^subnet.Mask[i]
+		}
+		return []Range{{RangeStart: start, RangeEnd: end}}, ""
+	case strings.Contains(tok, "["):
+		return parseBrackets(tok)
+	case strings.Contains(tok, "-"):
+		parts := strings.SplitN(tok, "-", 2)
+		start, end := parseIP(strings.TrimSpace(parts[0])), parseIP(strings.TrimSpace(parts[1]))
+		if start == nil {
+			return nil, fmt.Sprintf("invalid start address %q", parts[0])
+		}
+		if end == nil {
+			return nil, fmt.Sprintf("invalid end address %q", parts[1])
+		}
+		if len(start) != len(end) {
+			return nil, "start and end addresses are of different families"
+		}
+		if compareIP(start, end) > 0 {
+			return nil, "start address is after end address"
+		}
+		return []Range{{RangeStart: start, RangeEnd: end}}, ""
+	default:
+		addr := parseIP(tok)
+		if addr == nil {
+			return nil, "invalid address"
+		}
+		return []Range{{RangeStart: addr, RangeEnd: addr}}, ""
+	}
+}
+
+// parseBrackets parses an IPv4 range with some of its bytes written as
+// [x-y] or [x]. The last byte varies within each range, the others are
+// expanded to one range per combination.
+func parseBrackets(tok string) ([]Range, string) {
+	parts := strings.Split(tok, ".")
+	if len(parts) != net.IPv4len {
+		return nil, "bracket ranges must have 4 bytes"
+	}
+
+	var lows, highs [net.IPv4len]int
+	count := 1
+	for i, part := range parts {
+		lo, hi, msg := parseByte(part)
+		if msg != "" {
+			return nil, fmt.Sprintf("byte %d: %s", i+1, msg)
+		}
+		lows[i], highs[i] = lo, hi
+		if i < net.IPv4len-1 {
+			count *= hi - lo + 1
+		}
+	}
+	if count > maxBracketRanges {
+		return nil, fmt.Sprintf("expands to more than %d ranges", maxBracketRanges)
+	}
+
+	ret := make([]Range, 0, count)
+	cur := lows
+	for {
+		ret = append(ret, Range{
+			RangeStart: net.IPv4(byte(cur[0]), byte(cur[1]), byte(cur[2]), byte(lows[3])).To4(),
+			RangeEnd:   net.IPv4(byte(cur[0]), byte(cur[1]), byte(cur[2]), byte(highs[3])).To4(),
+		})
+		// Next combination of the first three bytes, the last one varying
+		// fastest.
+		i := net.IPv4len - 2
+		for ; i >= 0; i-- {
+			if cur[i] < highs[i] {
+				cur[i]++
+				break
+			}
+			cur[i] = lows[i]
+		}
+		if i < 0 {
+			return ret, ""
+		}
+	}
+}
+
+// parseByte parses a byte of a bracket range, n, [x-y] or [x].
+func parseByte(s string) (int, int, string) {
+	if !strings.HasPrefix(s, "[") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > 255 {
+			return 0, 0, fmt.Sprintf("invalid byte %q", s)
+		}
+		return n, n, ""
+	}
+	if !strings.HasSuffix(s, "]") {
+		return 0, 0, fmt.Sprintf("missing ] in %q", s)
+	}
+	bounds := strings.Split(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"), "-")
+	if len(bounds) > 2 {
+		return 0, 0, fmt.Sprintf("too many - in %q", s)
+	}
+	lo, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil || lo < 0 || lo > 255 {
+		return 0, 0, fmt.Sprintf("invalid byte %q", bounds[0])
+	}
+	hi := lo
+	if len(bounds) == 2 {
+		hi, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if err != nil || hi < 0 || hi > 255 {
+			return 0, 0, fmt.Sprintf("invalid byte %q", bounds[1])
+		}
+	}
+	if lo > hi {
+		return 0, 0, fmt.Sprintf("%d is after %d in %q", lo, hi, s)
+	}
+	return lo, hi, ""
+}
+
+// parseIP parses an address, IPv4 ones in their 4 bytes form.
+func parseIP(s string) net.IP {
+	addr := net.ParseIP(s)
+	if v4 := addr.To4(); v4 != nil {
+		return v4
+	}
+	return addr
+}
+
+// subtractRanges removes the addresses of excluded from ranges, both sorted
+// and merged.
+func subtractRanges(ranges, excluded []Range) []Range {
+	ret := []Range{}
+	for _, r := range ranges {
+		start := r.RangeStart
+		for _, x := range excluded {
+			if compareIP(x.RangeEnd, start) < 0 || compareIP(x.RangeStart, r.RangeEnd) > 0 {
+				continue
+			}
+			if compareIP(x.RangeStart, start) > 0 {
+				ret = append(ret, Range{RangeStart: start, RangeEnd: addIP(x.RangeStart, -1)})
+			}
+			if compareIP(x.RangeEnd, r.RangeEnd) >= 0 {
+				start = nil
+				break
+			}
+			start = addIP(x.RangeEnd, 1)
+		}
+		if start != nil {
+			ret = append(ret, Range{RangeStart: start, RangeEnd: r.RangeEnd})
+		}
+	}
+	return ret
+}
+
+// mergeRanges sorts ranges and merges those that overlap or are adjacent.
+func mergeRanges(ranges []Range) []Range {
+	sort.Slice(ranges, func(i, j int) bool {
+		return compareIP(ranges[i].RangeStart, ranges[j].RangeStart) < 0
+	})
+	ret := []Range{}
+	for _, r := range ranges {
+		last := len(ret) - 1
+		if last >= 0 && compareIP(r.RangeStart, addIP(ret[last].RangeEnd, 1)) <= 0 {
+			if compareIP(r.RangeEnd, ret[last].RangeEnd) > 0 {
+				ret[last].RangeEnd = r.RangeEnd
+			}
+			continue
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// compareIP compares IPv4 addresses whatever their length.
+func compareIP(a, b net.IP) int {
+	return bytes.Compare(a.To16(), b.To16())
+}
+
+// addIP returns the address n after addr, of the same length. It wraps
+// around past the last address, and must not go before the first one.
+func addIP(addr net.IP, n int64) net.IP {
+	if v4 := addr.To4(); v4 != nil {
+		addr = v4
+	}
+	i := new(big.Int).SetBytes(addr)
+	b := i.Add(i, big.NewInt(n)).Bytes()
+	ret := make(net.IP, len(addr))
+	if len(b) > len(ret) {
+		b = b[len(b)-len(ret):]
+	}
+	copy(ret[len(ret)-len(b):], b)
+	return ret
+}
+
+// rangeBigSize is the number of addresses of r, both ends included.
+func rangeBigSize(r Range) *big.Int {
+	start := new(big.Int).SetBytes(r.RangeStart.To16())
+	size := new(big.Int).SetBytes(r.RangeEnd.To16())
+	size.Sub(size, start)
+	if size.Sign() < 0 {
+		return size.SetInt64(0)
+	}
+	return size.Add(size, big.NewInt(1))
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("range parser", func() {
+	ranges := func(s string) []string {
+		rs, err := LoadRangeSet(s)
+		Expect(err).NotTo(HaveOccurred())
+		ret := []string{}
+		for _, r := range *rs {
+			ret = append(ret, r.String())
+		}
+		return ret
+	}
+
+	It("parses the ranges pools are stored as", func() {
+		Expect(ranges("10.0.1.[4-8], 10.0.1.20")).To(Equal([]string{"10.0.1.4-10.0.1.8", "10.0.1.20-10.0.1.20"}))
+	})
+
+	It("parses CIDR blocks and ranges across bytes", func() {
+		Expect(ranges("10.0.1.0/30")).To(Equal([]string{"10.0.1.0-10.0.1.3"}))
+		Expect(ranges("10.0.1.250-10.0.2.10")).To(Equal([]string{"10.0.1.250-10.0.2.10"}))
+	})
+
+	It("parses brackets in any byte", func() {
+		Expect(ranges("10.0.[1-2].[10-19]")).To(Equal([]string{"10.0.1.10-10.0.1.19", "10.0.2.10-10.0.2.19"}))
+		Expect(ranges("10.[1-2].0.1")).To(Equal([]string{"10.1.0.1-10.1.0.1", "10.2.0.1-10.2.0.1"}))
+		Expect(ranges("10.0.[1].[5]")).To(Equal([]string{"10.0.1.5-10.0.1.5"}))
+	})
+
+	It("parses IPv6", func() {
+		Expect(ranges("2001:db8::1-2001:db8::ff, 2001:db8:1::/126")).
+			To(Equal([]string{"2001:db8::1-2001:db8::ff", "2001:db8:1::-2001:db8:1::3"}))
+	})
+
+	It("removes the excluded ranges", func() {
+		Expect(ranges("!10.0.1.0, 10.0.1.0/28, !10.0.1.[5-6], !10.0.1.15")).
+			To(Equal([]string{"10.0.1.1-10.0.1.4", "10.0.1.7-10.0.1.14"}))
+		Expect(ranges("10.0.1.5, !10.0.1.0/24")).To(BeEmpty())
+	})
+
+	It("merges overlapping and adjacent ranges", func() {
+		Expect(ranges("10.0.1.[1-3], 10.0.1.4, 10.0.1.[2-9]")).To(Equal([]string{"10.0.1.1-10.0.1.9"}))
+	})
+
+	It("names the invalid token and its position", func() {
+		for _, c := range []struct {
+			input string
+			token string
+			pos   int
+		}{
+			{"10.0.1.x", "10.0.1.x", 1},
+			{"10.0.1.5, 10.0.1.[4", "10.0.1.[4", 11},
+			{"10.0.1.5,10.0.1.[4-]", "10.0.1.[4-]", 10},
+			{"10.0.1.[9-4]", "10.0.1.[9-4]", 1},
+			{"10.0.1.9-10.0.1.4", "10.0.1.9-10.0.1.4", 1},
+			{"10.0.1.1-2001:db8::1", "10.0.1.1-2001:db8::1", 1},
+			{"10.0.1.1,,10.0.1.2", "", 10},
+			{"10.0.1.1, !10.0.300.1", "!10.0.300.1", 11},
+			{"[0-255].[0-255].[0-255].1", "[0-255].[0-255].[0-255].1", 1},
+		} {
+			_, err := LoadRangeSet(c.input)
+			Expect(err).To(HaveOccurred(), c.input)
+			rangeErr, ok := err.(*RangeError)
+			Expect(ok).To(BeTrue(), c.input)
+			Expect(rangeErr.Token).To(Equal(c.token), c.input)
+			Expect(rangeErr.Pos).To(Equal(c.pos), c.input)
+		}
+
+		_, err := LoadRangeSet(" ")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("keeps the addresses of a subnet", func() {
+		_, subnet, err := net.ParseCIDR("10.0.1.0/24")
+		Expect(err).NotTo(HaveOccurred())
+		rs, err := LoadRangeSetInSubnet("10.0.0.250-10.0.1.5, 10.0.2.[1-9]", subnet)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*rs).To(HaveLen(1))
+		Expect((*rs)[0].String()).To(Equal("10.0.1.0-10.0.1.5"))
+	})
+})
@@ -16,9 +16,10 @@ package allocator
 
 import (
 	"fmt"
+	"math/big"
 	"net"
-	"strings"
 	"sort"
+	"strings"
 
 	"github.com/containernetworking/plugins/pkg/ip"
 )
@@ -76,6 +77,20 @@ func (s *RangeSet) Overlaps(p1 *RangeSet) bool {
 	return false
 }
 
+// FirstOverlap returns the first range of s overlapping a range of s1, nil if
+// none does. Unlike Overlaps, it doesn't need the subnet of the ranges, so it
+// works on the range sets of pools.
+func (s *RangeSet) FirstOverlap(s1 *RangeSet) *Range {
+	for i, r := range *s {
+		for _, r1 := range *s1 {
+			if ip.Cmp(r.RangeStart, r1.RangeEnd) <= 0 && ip.Cmp(r1.RangeStart, r.RangeEnd) <= 0 {
+				return &(*s)[i]
+			}
+		}
+	}
+	return nil
+}
+
 // Canonicalize ensures the RangeSet is in a standard form, and detects any
 // invalid input. Call Range.Canonicalize() on every Range in the set
 func (s *RangeSet) Canonicalize() error {
@@ -119,7 +134,6 @@ func (s *RangeSet) String() string {
 	return strings.Join(out, ",")
 }
 
-
 func (s RangeSet) Len() int {
 	return len(s)
 }
@@ -137,147 +151,199 @@ func (s RangeSet) Less(i, j int) bool {
 	return ip.Cmp(a.RangeEnd, b.RangeEnd) < 0
 }
 
-// LoadRangeSet loads RangeSet from string. eg: "10.0.0.[2-4], 10.0.1.4, 10.0.1.5, 10.0.1.9",
-// this func return RangeSet with 3 ranges contained. No subnet and gateway information here.
+// LoadRangeSet loads RangeSet from string, in the format of parseRanges. eg:
+// "10.0.0.[2-4], 10.0.1.4, 10.0.1.0/28, !10.0.1.0". No subnet and gateway
+// information here.
 func LoadRangeSet(ipAddrs string) (*RangeSet, error) {
-	if ipAddrs == "" {
-		return nil, fmt.Errorf("Input of IP ranges is empty")
+	ranges, err := parseRanges(ipAddrs)
+	if err != nil {
+		return nil, err
 	}
-	ret := RangeSet{}
-	ranges := strings.Split(ipAddrs, ",")
-
-	for _, r := range ranges {
-		// Remove all lead blanks and tailed blanks.
-		r = strings.TrimSpace(r)
-		if strings.HasSuffix(r, "]") {
-			// eg: ["10.0.1.", "4-8"]
-			segments := strings.Split(strings.TrimSuffix(r, "]"), "[")
-			suffixs := strings.Split(segments[1], "-")
-
-			start := net.ParseIP(segments[0] + suffixs[0])
-			end := net.ParseIP(segments[0] + suffixs[1])
-			if start == nil || end == nil {
-				return nil, fmt.Errorf("Input of IP ranges is valid")
-			}
+	ret := RangeSet(ranges)
+	return &ret, nil
+}
 
-			ret = append(ret, Range{
-				RangeStart: start,
-				RangeEnd: end,
-			})
-		} else {
-			// eg: 10.1.8.9
-			current := net.ParseIP(r)
-			if current == nil {
-				return nil, fmt.Errorf("Input of IP range is valid")
-			}
-			ret = append(ret, Range{
-				RangeStart: current,
-				RangeEnd: current,
-			})
-		}
+// FormatRangeSet is the reverse of LoadRangeSet, it returns the ranges of s
+// in the format pools are stored in. eg: "10.0.0.[2-4],10.0.1.4". Ranges
+// within a /24 use the bracket form, the others are written as a CIDR block
+// when they are one, and as start-end otherwise, so that any range set
+// loaded can be written back, IPv6 included.
+func FormatRangeSet(s *RangeSet) (string, error) {
+	out := make([]string, 0, len(*s))
+	for _, r := range *s {
+		out = append(out, formatRange(r.RangeStart, r.RangeEnd))
 	}
-	sort.Sort(ret)
-
-	cursor := 0
-	l := len(ret)
-	i := 1
-	for ; i < l; i++ {
-		// A gap here
-		if ip.Cmp(ret[cursor].RangeEnd, ret[i].RangeStart) < 0 && !ret[i].RangeStart.Equal(ip.NextIP(ret[cursor].RangeEnd)) {
-			if i > cursor + 1 {
-				ret = append(ret[:cursor + 1], ret[i:]...)
-				gap := i - cursor - 1
-				l -= gap
-				i -= gap
+	return strings.Join(out, ","), nil
+}
 
-			}
-			cursor += 1
-		} else { // overlap case
-			if ip.Cmp(ret[i].RangeEnd, ret[cursor].RangeEnd) > 0 {
-				ret[cursor].RangeEnd = ret[i].RangeEnd
-			}
-		}
+func formatRange(start, end net.IP) string {
+	if start.Equal(end) {
+		return start.String()
 	}
-
-	if i > cursor + 1 {
-		ret = append(ret[:cursor + 1], ret[i:]...)
+	if start4, end4 := start.To4(), end.To4(); start4 != nil && end4 != nil &&
+		start4[0] == end4[0] && start4[1] == end4[1] && start4[2] == end4[2] {
+		return fmt.Sprintf("%d.%d.%d.[%d-%d]", start4[0], start4[1], start4[2], start4[3], end4[3])
 	}
-	return &ret, nil
+	if start4 := start.To4(); start4 != nil {
+		start, end = start4, end.To4()
+	}
+	bits := len(start) * 8
+	for ones := 0; ones <= bits; ones++ {
+		mask := net.CIDRMask(ones, bits)
+		if !start.Mask(mask).Equal(start) {
+			continue
+		}
+		last := make(net.IP, len(start))
+		for i := range start {
+			last[i] = start[i] | ^mask[i]
+		}
+		if last.Equal(end) {
+			return (&net.IPNet{IP: start, Mask: mask}).String()
+		}
+	}
+	return start.String() + "-" + end.String()
 }
 
-// LoadRangeSetInSubnet loads RangeSet from string for given subnet.
+// LoadRangeSetInSubnet loads RangeSet from string for given subnet, keeping
+// the addresses in it only.
 // eg: "10.0.0.[2-4], 10.0.1.4, 10.0.1.5, 10.0.1.9" 10.0.1.0/24
 // this func return RangeSet with 2 ranges contained. No subnet and gateway information here.
 func LoadRangeSetInSubnet(ipAddrs string, subnet *net.IPNet) (*RangeSet, error) {
-	if ipAddrs == "" {
-		return nil, fmt.Errorf("Input of IP ranges is empty")
+	ranges, err := parseRanges(ipAddrs)
+	if err != nil {
+		return nil, err
+	}
+	first := subnet.IP.Mask(subnet.Mask)
+	last := make(net.IP, len(first))
+	for i := range first {
+		last[i] = first[i] | ^subnet.Mask[i]
 	}
-	ret := RangeSet{}
-	ranges := strings.Split(ipAddrs, ",")
 
+	ret := RangeSet{}
 	for _, r := range ranges {
-		// Remove all lead blanks and tailed blanks.
-		r = strings.TrimSpace(r)
-		if strings.HasSuffix(r, "]") {
-			// eg: ["10.0.1.", "4-8"]
-			segments := strings.Split(strings.TrimSuffix(r, "]"), "[")
-			suffixs := strings.Split(segments[1], "-")
-
-			start := net.ParseIP(segments[0] + suffixs[0])
-			if !subnet.Contains(start) {
-				// This range don't belong to the subnet, so continue here.
-				continue
-			}
-			end := net.ParseIP(segments[0] + suffixs[1])
-			if start == nil || end == nil {
-				return nil, fmt.Errorf("Input of IP ranges is valid")
-			}
+		if compareIP(r.RangeStart, first) < 0 {
+			r.RangeStart = first
+		}
+		if compareIP(r.RangeEnd, last) > 0 {
+			r.RangeEnd = last
+		}
+		if compareIP(r.RangeStart, r.RangeEnd) <= 0 {
+			ret = append(ret, r)
+		}
+	}
+	return &ret, nil
+}
+
+// The set algebra below doesn't need the subnet of the ranges, like
+// FirstOverlap, and returns sorted and merged sets whatever its operands.
+
+// Union returns the addresses in s or in s1.
+func (s *RangeSet) Union(s1 *RangeSet) *RangeSet {
+	ranges := make([]Range, 0, len(*s)+len(*s1))
+	ranges = append(ranges, *s...)
+	ranges = append(ranges, *s1...)
+	ret := RangeSet(mergeRanges(ranges))
+	return &ret
+}
 
-			ret = append(ret, Range{
-				RangeStart: start,
-				RangeEnd: end,
-			})
+// Intersect returns the addresses both in s and in s1.
+func (s *RangeSet) Intersect(s1 *RangeSet) *RangeSet {
+	a, b := s.normalized(), s1.normalized()
+	ret := RangeSet{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		start, end := a[i].RangeStart, a[i].RangeEnd
+		if compareIP(b[j].RangeStart, start) > 0 {
+			start = b[j].RangeStart
+		}
+		if compareIP(b[j].RangeEnd, end) < 0 {
+			end = b[j].RangeEnd
+		}
+		if compareIP(start, end) <= 0 {
+			ret = append(ret, Range{RangeStart: start, RangeEnd: end})
+		}
+		// The range ending first can't overlap the next ones of the other.
+		if compareIP(a[i].RangeEnd, b[j].RangeEnd) < 0 {
+			i++
 		} else {
-			// eg: 10.1.8.9
-			current := net.ParseIP(r)
-			if current == nil {
-				return nil, fmt.Errorf("Input of IP range is valid")
-			}
-			if !subnet.Contains(current) {
-				// This range don't belong to the subnet, so continue here.
-				continue
-			}
-			ret = append(ret, Range{
-				RangeStart: current,
-				RangeEnd: current,
-			})
+			j++
 		}
 	}
-	sort.Sort(ret)
-
-	cursor := 0
-	l := len(ret)
-	i := 1
-	for ; i < l; i++ {
-		// A gap here
-		if ip.Cmp(ret[cursor].RangeEnd, ret[i].RangeStart) < 0 && !ret[i].RangeStart.Equal(ip.NextIP(ret[cursor].RangeEnd)) {
-			if i > cursor + 1 {
-				ret = append(ret[:cursor + 1], ret[i:]...)
-				gap := i - cursor - 1
-				l -= gap
-				i -= gap
+	return &ret
+}
 
-			}
-			cursor += 1
-		} else { // overlap case
-			if ip.Cmp(ret[i].RangeEnd, ret[cursor].RangeEnd) > 0 {
-				ret[cursor].RangeEnd = ret[i].RangeEnd
-			}
+// Subtract returns the addresses in s but not in s1.
+func (s *RangeSet) Subtract(s1 *RangeSet) *RangeSet {
+	ret := RangeSet(subtractRanges(s.normalized(), s1.normalized()))
+	return &ret
+}
+
+// Size returns the number of addresses in s.
+func (s *RangeSet) Size() *big.Int {
+	size := big.NewInt(0)
+	for _, r := range s.normalized() {
+		size.Add(size, rangeBigSize(r))
+	}
+	return size
+}
+
+// Includes tells whether addr is in s. Unlike Contains, it doesn't need the
+// subnet of the ranges, and s must be sorted and merged.
+func (s *RangeSet) Includes(addr net.IP) bool {
+	i := sort.Search(len(*s), func(i int) bool {
+		return compareIP((*s)[i].RangeEnd, addr) >= 0
+	})
+	return i < len(*s) && compareIP((*s)[i].RangeStart, addr) <= 0
+}
+
+// normalized returns the ranges of s sorted and merged, without changing s.
+func (s *RangeSet) normalized() []Range {
+	return mergeRanges(append([]Range{}, *s...))
+}
+
+// RangeSetOf returns the set of addrs, which may be in any order.
+func RangeSetOf(addrs []net.IP) *RangeSet {
+	ranges := make([]Range, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr == nil {
+			continue
 		}
+		ranges = append(ranges, Range{RangeStart: addr, RangeEnd: addr})
 	}
+	ret := RangeSet(mergeRanges(ranges))
+	return &ret
+}
 
-	if i > cursor + 1 {
-		ret = append(ret[:cursor + 1], ret[i:]...)
+// FreeIter walks the addresses of a set that aren't used, in order.
+type FreeIter struct {
+	free RangeSet
+	idx  int
+	next net.IP
+}
+
+// FreeIPs returns an iterator over the addresses of s that aren't in used,
+// which is computed in O((len(s) + len(used)) log(len(s) + len(used))) and
+// not address by address.
+func (s *RangeSet) FreeIPs(used *RangeSet) *FreeIter {
+	free := s.Subtract(used)
+	it := &FreeIter{free: *free}
+	if len(it.free) > 0 {
+		it.next = it.free[0].RangeStart
 	}
-	return &ret, nil
+	return it
+}
+
+// Next returns the next free address, nil once there is none.
+func (it *FreeIter) Next() net.IP {
+	if it.next == nil {
+		return nil
+	}
+	ret := it.next
+	if compareIP(ret, it.free[it.idx].RangeEnd) < 0 {
+		it.next = addIP(ret, 1)
+	} else if it.idx++; it.idx < len(it.free) {
+		it.next = it.free[it.idx].RangeStart
+	} else {
+		it.next = nil
+	}
+	return ret
 }
@@ -22,6 +22,12 @@ import (
 )
 
 var _ = Describe("range sets", func() {
+	load := func(s string) *RangeSet {
+		rs, err := LoadRangeSet(s)
+		Expect(err).NotTo(HaveOccurred())
+		return rs
+	}
+
 	It("should detect set membership correctly", func() {
 		p := RangeSet{
 			Range{Subnet: mustSubnet("192.168.0.0/24")},
@@ -75,4 +81,98 @@ var _ = Describe("range sets", func() {
 		r2 := LoadRangeSet(p2)
 		Expect(p1.IsSubset(&p2)).To(BeTrue())
 	})
+
+	It("should format range sets the way they are loaded", func() {
+		for _, pool := range []string{
+			"10.0.0.[2-4],10.0.1.4",
+			"10.0.0.250-10.0.1.3",
+			"10.0.1.9",
+			"10.1.0.0/16",
+			"10.2.0.0/15,10.4.0.1-10.4.1.0",
+			"2001:db8::1",
+			"2001:db8::/64",
+			"2001:db8:1::5-2001:db8:1::1:4",
+		} {
+			rs, err := LoadRangeSet(pool)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(FormatRangeSet(rs)).To(Equal(pool))
+		}
+
+		rs, err := LoadRangeSet("10.0.0.[1-3], 10.0.0.4, 10.0.0.5")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(FormatRangeSet(rs)).To(Equal("10.0.0.[1-5]"))
+	})
+
+	It("should load back whatever it formats", func() {
+		for _, pool := range []string{
+			"10.0.0.[250-255],10.0.1.[0-3]",
+			"10.0.[0-3].[0-255],!10.0.2.7",
+			"192.168.0.0/22,192.168.4.5",
+			"2001:db8::/48,!2001:db8::1",
+			"2001:db8::1-2001:db8::ff,2001:db8::1:0/112",
+		} {
+			rs := load(pool)
+			s, err := FormatRangeSet(rs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(load(s).String()).To(Equal(rs.String()))
+		}
+	})
+
+	It("should find the overlaps of pools", func() {
+		other := load("10.0.1.[10-19],10.0.2.5")
+		Expect(load("10.0.1.[2-9]").FirstOverlap(other)).To(BeNil())
+		Expect(load("10.0.1.[2-10]").FirstOverlap(other).String()).To(Equal("10.0.1.2-10.0.1.10"))
+		Expect(load("10.0.2.[1-9]").FirstOverlap(other).String()).To(Equal("10.0.2.1-10.0.2.9"))
+		Expect(load("10.0.1.15").FirstOverlap(other).String()).To(Equal("10.0.1.15-10.0.1.15"))
+	})
+
+	Describe("set algebra", func() {
+		format := func(rs *RangeSet) string {
+			s, err := FormatRangeSet(rs)
+			Expect(err).NotTo(HaveOccurred())
+			return s
+		}
+		a := load("10.0.1.[1-10],10.0.1.20")
+		b := load("10.0.1.[5-15],10.0.2.1")
+
+		It("computes unions", func() {
+			Expect(format(a.Union(b))).To(Equal("10.0.1.[1-15],10.0.1.20,10.0.2.1"))
+		})
+
+		It("computes intersections", func() {
+			Expect(format(a.Intersect(b))).To(Equal("10.0.1.[5-10]"))
+			Expect(*a.Intersect(load("10.0.3.1"))).To(BeEmpty())
+		})
+
+		It("computes differences", func() {
+			Expect(format(a.Subtract(b))).To(Equal("10.0.1.[1-4],10.0.1.20"))
+			Expect(format(b.Subtract(a))).To(Equal("10.0.1.[11-15],10.0.2.1"))
+		})
+
+		It("counts the addresses", func() {
+			Expect(a.Size().Int64()).To(Equal(int64(11)))
+			Expect(load("2001:db8::/64").Size().String()).To(Equal("18446744073709551616"))
+		})
+
+		It("finds addresses without the subnet of the ranges", func() {
+			Expect(a.Includes(net.ParseIP("10.0.1.10"))).To(BeTrue())
+			Expect(a.Includes(net.ParseIP("10.0.1.11"))).To(BeFalse())
+			Expect(a.Includes(net.ParseIP("10.0.1.20"))).To(BeTrue())
+			Expect(a.Includes(net.ParseIP("10.0.0.1"))).To(BeFalse())
+		})
+
+		It("walks the free addresses in order", func() {
+			used := RangeSetOf([]net.IP{
+				net.ParseIP("10.0.1.3"), net.ParseIP("10.0.1.1"), net.ParseIP("10.0.1.2"), net.ParseIP("10.0.1.9"),
+			})
+			it := load("10.0.1.[1-4],10.0.1.[8-10]").FreeIPs(used)
+			free := []string{}
+			for addr := it.Next(); addr != nil; addr = it.Next() {
+				free = append(free, addr.String())
+			}
+			Expect(free).To(Equal([]string{"10.0.1.4", "10.0.1.8", "10.0.1.10"}))
+
+			Expect(load("10.0.1.1").FreeIPs(load("10.0.1.0/24")).Next()).To(BeNil())
+		})
+	})
 })
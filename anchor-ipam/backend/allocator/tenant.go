@@ -0,0 +1,59 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import "fmt"
+
+// ResolveTenant returns the tenant whose pool the pods of namespace are
+// allocated from, given the tenants and the namespace bindings of the store.
+// currentUser is the tenant the pod asks for, if any:
+//
+//   - a namespace bound to a tenant only gets its pool, and only pods asking
+//     for nothing or that tenant are allocated,
+//   - otherwise the namespace owns its pool, as before tenants, and pods
+//     asking for a tenant are refused: the annotation may be set by anyone
+//     able to create pods, or inherited from the namespace or workload, so
+//     only a binding gives the pool of a tenant.
+//
+// The pools of tenants and namespaces share their keys, so a namespace
+// named after a tenant must be bound to get a pool: it would otherwise take
+// that of the tenant.
+func ResolveTenant(tenants, bindings map[string]string, namespace, currentUser string) (string, error) {
+	if tenant, ok := bindings[namespace]; ok {
+		if currentUser != "" && currentUser != tenant {
+			return "", allocError(ReasonTenantDenied, fmt.Sprintf("namespace %s is bound to tenant %s, not %s", namespace, tenant, currentUser))
+		}
+		return tenant, nil
+	}
+	if currentUser != "" && currentUser != namespace {
+		if _, ok := tenants[currentUser]; !ok {
+			return "", allocError(ReasonTenantDenied, fmt.Sprintf("tenant %s doesn't exist", currentUser))
+		}
+		return "", allocError(ReasonTenantDenied, fmt.Sprintf("namespace %s isn't bound to tenant %s", namespace, currentUser))
+	}
+	if _, ok := tenants[namespace]; ok {
+		return "", allocError(ReasonTenantDenied, fmt.Sprintf("namespace %s has the name of a tenant but isn't bound to it", namespace))
+	}
+	return namespace, nil
+}
+
+// describePool names the pool of tenant for the errors about pods of
+// namespace.
+func describePool(tenant, namespace string) string {
+	if tenant == namespace {
+		return "namespace " + namespace
+	}
+	return "tenant " + tenant
+}
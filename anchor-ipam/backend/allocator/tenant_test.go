@@ -0,0 +1,55 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("tenants", func() {
+	tenants := map[string]string{"user01": "", "user02": ""}
+	bindings := map[string]string{"shop-prod": "user01", "shop-dev": "user01"}
+
+	It("allocates the namespaces bound to a tenant from its pool", func() {
+		Expect(ResolveTenant(tenants, bindings, "shop-prod", "")).To(Equal("user01"))
+		Expect(ResolveTenant(tenants, bindings, "shop-dev", "user01")).To(Equal("user01"))
+
+		_, err := ResolveTenant(tenants, bindings, "shop-prod", "user02")
+		Expect(ErrorReason(err)).To(Equal(ReasonTenantDenied))
+	})
+
+	It("only gives the pool of a tenant to the namespaces bound to it", func() {
+		_, err := ResolveTenant(tenants, bindings, "default", "user02")
+		Expect(ErrorReason(err)).To(Equal(ReasonTenantDenied))
+
+		_, err = ResolveTenant(tenants, bindings, "default", "nobody")
+		Expect(ErrorReason(err)).To(Equal(ReasonTenantDenied))
+
+		Expect(ResolveTenant(tenants, bindings, "default", "default")).To(Equal("default"))
+	})
+
+	It("allocates the other pods from the pool of their namespace", func() {
+		Expect(ResolveTenant(tenants, bindings, "default", "")).To(Equal("default"))
+		Expect(ResolveTenant(nil, nil, "default", "")).To(Equal("default"))
+	})
+
+	It("keeps the namespaces named after a tenant out of its pool", func() {
+		_, err := ResolveTenant(tenants, bindings, "user02", "")
+		Expect(ErrorReason(err)).To(Equal(ReasonTenantDenied))
+
+		Expect(ResolveTenant(tenants, map[string]string{"user02": "user02"}, "user02", "")).To(Equal("user02"))
+	})
+})
@@ -0,0 +1,125 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"fmt"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+// TransferOptions tell what to do with the addresses of a transfer in use.
+type TransferOptions struct {
+	// MoveAllocations records the allocations of the addresses in the new
+	// pool, otherwise they stay in the old one until released.
+	MoveAllocations bool
+	// Force transfers addresses in use, which are refused otherwise.
+	Force bool
+}
+
+// PlanTransfer returns the transfer of ranges, in the format of
+// LoadRangeSet, from the pool of tenant from to the pool of tenant to, which
+// may be namespaces bound to no tenant.
+// allocs are the allocations of every namespace, and pending the
+// reservations not committed yet, which are in use but never moved.
+// The exclusions of the pool of from in the ranges move with them. The holds
+// of the ranges for the namespace from are rebound to to, as when a team
+// moves to a new namespace, and those for other namespaces are refused.
+func PlanTransfer(pools map[string]string, exclusions *backend.Exclusions, holds []*backend.Hold, allocs, pending []*backend.Allocation, from, to, ranges string, opts TransferOptions) (*backend.PoolTransfer, error) {
+	if from == to {
+		return nil, fmt.Errorf("can't transfer addresses from %s to itself", from)
+	}
+	moved, err := LoadRangeSet(ranges)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ranges %q: %v", ranges, err)
+	}
+	fromPool, ok := pools[from]
+	if !ok {
+		return nil, fmt.Errorf("%s has no pool", from)
+	}
+	fromSet, err := LoadRangeSet(fromPool)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pool of %s: %v", from, err)
+	}
+	if outside := moved.Subtract(fromSet); len(*outside) > 0 {
+		return nil, fmt.Errorf("%s is not in the pool of %s", (*outside)[0].String(), from)
+	}
+	toSet := &RangeSet{}
+	if p, ok := pools[to]; ok {
+		if toSet, err = LoadRangeSet(p); err != nil {
+			return nil, fmt.Errorf("invalid pool of %s: %v", to, err)
+		}
+	}
+
+	t := &backend.PoolTransfer{From: from, To: to}
+	inUse := 0
+	for _, a := range allocs {
+		if a.Owner() != from || !moved.Includes(a.IP) {
+			continue
+		}
+		inUse++
+		if opts.MoveAllocations {
+			t.Allocations = append(t.Allocations, a)
+		}
+	}
+	for _, a := range pending {
+		if a.Owner() == from && moved.Includes(a.IP) {
+			inUse++
+		}
+	}
+	if inUse > 0 && !opts.Force {
+		return nil, fmt.Errorf("%d addresses of %s are in use in the pool of %s, use force to transfer them anyway", inUse, ranges, from)
+	}
+
+	for _, h := range holds {
+		if !moved.Includes(h.IP) {
+			continue
+		}
+		if h.Namespace != from {
+			return nil, fmt.Errorf("%s is held for %s/%s, release it first", h.IP, h.Namespace, h.Service)
+		}
+		rebound := *h
+		rebound.Namespace = to
+		t.Holds = append(t.Holds, &rebound)
+	}
+
+	fromExcluded, toExcluded := &RangeSet{}, &RangeSet{}
+	if exclusions != nil {
+		if e, ok := exclusions.Pools[from]; ok {
+			if fromExcluded, err = LoadRangeSet(e); err != nil {
+				return nil, fmt.Errorf("invalid exclusions of the pool of %s: %v", from, err)
+			}
+		}
+		if e, ok := exclusions.Pools[to]; ok {
+			if toExcluded, err = LoadRangeSet(e); err != nil {
+				return nil, fmt.Errorf("invalid exclusions of the pool of %s: %v", to, err)
+			}
+		}
+	}
+
+	if t.FromPool, err = FormatRangeSet(fromSet.Subtract(moved)); err != nil {
+		return nil, err
+	}
+	if t.ToPool, err = FormatRangeSet(toSet.Union(moved)); err != nil {
+		return nil, err
+	}
+	if t.FromExclusions, err = FormatRangeSet(fromExcluded.Subtract(moved)); err != nil {
+		return nil, err
+	}
+	if t.ToExclusions, err = FormatRangeSet(toExcluded.Union(fromExcluded.Intersect(moved))); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
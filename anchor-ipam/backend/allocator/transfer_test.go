@@ -0,0 +1,135 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"net"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("pool transfer", func() {
+	pools := map[string]string{
+		"team-a": "10.0.1.[10-29]",
+		"team-b": "10.0.2.[10-19]",
+	}
+	allocs := []*backend.Allocation{
+		testAllocation("10.0.1.12", "team-a", "shop", "web"),
+		testAllocation("10.0.1.25", "team-a", "shop", "db"),
+	}
+
+	It("moves free ranges between pools", func() {
+		t, err := PlanTransfer(pools, nil, nil, allocs, nil, "team-a", "team-b", "10.0.1.[20-24]", TransferOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.FromPool).To(Equal("10.0.1.[10-19],10.0.1.[25-29]"))
+		Expect(t.ToPool).To(Equal("10.0.1.[20-24],10.0.2.[10-19]"))
+		Expect(t.Allocations).To(BeEmpty())
+	})
+
+	It("creates the pool of the new namespace and deletes the emptied one", func() {
+		t, err := PlanTransfer(pools, nil, nil, nil, nil, "team-a", "team-c", "10.0.1.[10-29]", TransferOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.FromPool).To(Equal(""))
+		Expect(t.ToPool).To(Equal("10.0.1.[10-29]"))
+	})
+
+	It("refuses ranges in use unless forced", func() {
+		_, err := PlanTransfer(pools, nil, nil, allocs, nil, "team-a", "team-b", "10.0.1.[10-14]", TransferOptions{})
+		Expect(err).To(HaveOccurred())
+
+		pending := []*backend.Allocation{testAllocation("10.0.1.20", "team-a", "shop", "web")}
+		_, err = PlanTransfer(pools, nil, nil, allocs, pending, "team-a", "team-b", "10.0.1.20", TransferOptions{})
+		Expect(err).To(HaveOccurred())
+
+		t, err := PlanTransfer(pools, nil, nil, allocs, nil, "team-a", "team-b", "10.0.1.[10-14]", TransferOptions{Force: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.Allocations).To(BeEmpty())
+
+		t, err = PlanTransfer(pools, nil, nil, allocs, nil, "team-a", "team-b", "10.0.1.[10-14]", TransferOptions{Force: true, MoveAllocations: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.Allocations).To(Equal(allocs[:1]))
+	})
+
+	It("moves the exclusions of the ranges with them", func() {
+		exclusions := &backend.Exclusions{Pools: map[string]string{
+			"team-a": "10.0.1.[18-21]",
+			"team-b": "10.0.2.10",
+		}}
+		t, err := PlanTransfer(pools, exclusions, nil, nil, nil, "team-a", "team-b", "10.0.1.[20-29]", TransferOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.FromExclusions).To(Equal("10.0.1.[18-19]"))
+		Expect(t.ToExclusions).To(Equal("10.0.1.[20-21],10.0.2.10"))
+
+		t, err = PlanTransfer(pools, exclusions, nil, nil, nil, "team-a", "team-c", "10.0.1.[10-29]", TransferOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.FromExclusions).To(Equal(""))
+		Expect(t.ToExclusions).To(Equal("10.0.1.[18-21]"))
+	})
+
+	It("rebinds the holds of the ranges for the old namespace", func() {
+		holds := []*backend.Hold{
+			{IP: net.ParseIP("10.0.1.20"), Namespace: "team-a", Service: "web"},
+			{IP: net.ParseIP("10.0.1.10"), Namespace: "team-a", Service: "db"},
+		}
+		t, err := PlanTransfer(pools, nil, holds, nil, nil, "team-a", "team-b", "10.0.1.[20-24]", TransferOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.Holds).To(Equal([]*backend.Hold{
+			{IP: net.ParseIP("10.0.1.20"), Namespace: "team-b", Service: "web"},
+		}))
+		Expect(holds[0].Namespace).To(Equal("team-a"))
+
+		others := []*backend.Hold{{IP: net.ParseIP("10.0.1.21"), Namespace: "shop", Service: "web"}}
+		_, err = PlanTransfer(pools, nil, others, nil, nil, "team-a", "team-b", "10.0.1.[20-24]", TransferOptions{Force: true})
+		Expect(err).To(MatchError(ContainSubstring("held for shop/web")))
+	})
+
+	It("transfers ranges spanning several /24 as a block", func() {
+		wide := map[string]string{"team-a": "10.1.0.0/16"}
+		t, err := PlanTransfer(wide, nil, nil, nil, nil, "team-a", "team-b", "10.1.128.0/17", TransferOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.FromPool).To(Equal("10.1.0.0/17"))
+		Expect(t.ToPool).To(Equal("10.1.128.0/17"))
+
+		t, err = PlanTransfer(wide, nil, nil, nil, nil, "team-a", "team-b", "10.1.0.100-10.1.3.20", TransferOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.FromPool).To(Equal("10.1.0.[0-99],10.1.3.21-10.1.255.255"))
+		Expect(t.ToPool).To(Equal("10.1.0.100-10.1.3.20"))
+	})
+
+	It("transfers IPv6 ranges", func() {
+		v6 := map[string]string{"team-a": "2001:db8::/64"}
+		exclusions := &backend.Exclusions{Pools: map[string]string{"team-a": "2001:db8::1-2001:db8::f"}}
+		t, err := PlanTransfer(v6, exclusions, nil, nil, nil, "team-a", "team-b", "2001:db8::/120", TransferOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.FromPool).To(Equal("2001:db8::100-2001:db8::ffff:ffff:ffff:ffff"))
+		Expect(t.ToPool).To(Equal("2001:db8::/120"))
+		Expect(t.FromExclusions).To(Equal(""))
+		Expect(t.ToExclusions).To(Equal("2001:db8::1-2001:db8::f"))
+	})
+
+	It("refuses ranges outside the pool", func() {
+		_, err := PlanTransfer(pools, nil, nil, nil, nil, "team-a", "team-b", "10.0.1.[25-35]", TransferOptions{})
+		Expect(err).To(MatchError(ContainSubstring("not in the pool of team-a")))
+
+		_, err = PlanTransfer(pools, nil, nil, nil, nil, "team-c", "team-b", "10.0.1.10", TransferOptions{})
+		Expect(err).To(HaveOccurred())
+
+		_, err = PlanTransfer(pools, nil, nil, nil, nil, "team-a", "team-a", "10.0.1.10", TransferOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+})
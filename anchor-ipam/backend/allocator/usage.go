@@ -0,0 +1,92 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"math/big"
+	"net"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+// PoolUsage is the utilization of the pool of a namespace in a subnet.
+// Namespace is the tenant owning the pool, see ResolveTenant.
+type PoolUsage struct {
+	Subnet    string  `json:"subnet"`
+	Namespace string  `json:"namespace"`
+	Total     float64 `json:"total"`
+	Used      float64 `json:"used"`
+	Free      float64 `json:"free"`
+}
+
+// WorkloadKey identifies the addresses of a workload in a subnet.
+type WorkloadKey struct {
+	Subnet    string
+	Namespace string
+	App       string
+	Service   string
+}
+
+// PoolUsages returns the utilization of the pool of every namespace in every
+// registered subnet it has addresses in. The excluded addresses and the
+// gateway of a subnet don't count, they are never allocated.
+func PoolUsages(pools map[string]string, gateways []*backend.Gateway, allocs []*backend.Allocation, excl *Exclusions) []*PoolUsage {
+	ret := []*PoolUsage{}
+	for _, gw := range gateways {
+		for ns, pool := range pools {
+			rs, err := LoadRangeSetInSubnet(pool, gw.Subnet)
+			if err != nil || len(*rs) == 0 {
+				continue
+			}
+
+			excluded := excl.set(gw.Subnet, ns)
+			if gw.Gateway != nil {
+				excluded = excluded.Union(RangeSetOf([]net.IP{gw.Gateway}))
+			}
+			allocatable := rs.Subtract(excluded)
+
+			u := &PoolUsage{Subnet: gw.Subnet.String(), Namespace: ns, Total: bigFloat(allocatable.Size())}
+			for _, a := range allocs {
+				if a.Owner() == ns && allocatable.Includes(a.IP) {
+					u.Used++
+				}
+			}
+			u.Free = u.Total - u.Used
+			ret = append(ret, u)
+		}
+	}
+	return ret
+}
+
+// WorkloadUsages counts the addresses of every workload by subnet.
+func WorkloadUsages(gateways []*backend.Gateway, allocs []*backend.Allocation) map[WorkloadKey]float64 {
+	ret := map[WorkloadKey]float64{}
+	for _, a := range allocs {
+		subnet := ""
+		for _, gw := range gateways {
+			if gw.Subnet.Contains(a.IP) {
+				subnet = gw.Subnet.String()
+				break
+			}
+		}
+		ret[WorkloadKey{subnet, a.Namespace, a.App, a.Service}]++
+	}
+	return ret
+}
+
+func bigFloat(i *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(i).Float64()
+	return f
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"net"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func testGateway(subnet, gw string) *backend.Gateway {
+	_, n, err := net.ParseCIDR(subnet)
+	Expect(err).NotTo(HaveOccurred())
+	return &backend.Gateway{Subnet: n, Gateway: net.ParseIP(gw)}
+}
+
+func testAllocation(ip, namespace, app, service string) *backend.Allocation {
+	return &backend.Allocation{IP: net.ParseIP(ip), Namespace: namespace, App: app, Service: service}
+}
+
+var _ = Describe("pool usage", func() {
+	gateways := []*backend.Gateway{
+		testGateway("10.0.1.0/24", "10.0.1.1"),
+		testGateway("10.0.2.0/24", "10.0.2.1"),
+	}
+	allocs := []*backend.Allocation{
+		testAllocation("10.0.1.5", "team-a", "shop", "web"),
+		testAllocation("10.0.1.6", "team-a", "shop", "web"),
+		testAllocation("10.0.2.3", "team-a", "shop", "db"),
+		// outside the pool of its namespace
+		testAllocation("10.0.2.100", "team-b", "unknown", "unknown"),
+	}
+
+	It("counts the addresses of each pool by subnet", func() {
+		pools := map[string]string{
+			"team-a": "10.0.1.[1-10],10.0.2.3",
+			"team-b": "10.0.2.[10-19]",
+		}
+		usages := PoolUsages(pools, gateways, allocs, nil)
+		Expect(usages).To(HaveLen(3))
+
+		byKey := map[string]*PoolUsage{}
+		for _, u := range usages {
+			byKey[u.Subnet+" "+u.Namespace] = u
+		}
+		// .1 is the gateway
+		Expect(byKey["10.0.1.0/24 team-a"].Total).To(Equal(9.0))
+		Expect(byKey["10.0.1.0/24 team-a"].Used).To(Equal(2.0))
+		Expect(byKey["10.0.1.0/24 team-a"].Free).To(Equal(7.0))
+		Expect(byKey["10.0.2.0/24 team-a"].Total).To(Equal(1.0))
+		Expect(byKey["10.0.2.0/24 team-a"].Used).To(Equal(1.0))
+		Expect(byKey["10.0.2.0/24 team-b"].Total).To(Equal(10.0))
+		Expect(byKey["10.0.2.0/24 team-b"].Used).To(Equal(0.0))
+	})
+
+	It("doesn't count the excluded addresses", func() {
+		pools := map[string]string{
+			"team-a": "10.0.1.[0-10]",
+		}
+		exclusions, err := LoadExclusions(&backend.Exclusions{
+			Subnets: map[string]string{"10.0.1.0/24": "10.0.1.[1-3]"},
+			Pools:   map[string]string{"team-a": "10.0.1.3,10.0.1.6"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		usages := PoolUsages(pools, gateways[:1], allocs, exclusions)
+		Expect(usages).To(HaveLen(1))
+		// .0 is the network, .1 the gateway, .1-.3 and .6 are excluded.
+		Expect(usages[0].Total).To(Equal(6.0))
+		// .6 was allocated before it was excluded, it doesn't count.
+		Expect(usages[0].Used).To(Equal(1.0))
+		Expect(usages[0].Free).To(Equal(5.0))
+	})
+
+	It("counts the addresses of each workload by subnet", func() {
+		usages := WorkloadUsages(gateways, allocs)
+		Expect(usages).To(HaveLen(3))
+		Expect(usages[WorkloadKey{"10.0.1.0/24", "team-a", "shop", "web"}]).To(Equal(2.0))
+		Expect(usages[WorkloadKey{"10.0.2.0/24", "team-a", "shop", "db"}]).To(Equal(1.0))
+		Expect(usages[WorkloadKey{"10.0.2.0/24", "team-b", "unknown", "unknown"}]).To(Equal(1.0))
+	})
+})
@@ -0,0 +1,85 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"math/big"
+	"net"
+)
+
+// ChunkBits is the number of addresses of a chunk of an IPBitmap, 512 bytes.
+const ChunkBits = 4096
+
+// IPBitmap is the allocation index of a subnet: one bit per address, set
+// when it is allocated, counting from the network address. It is split in
+// chunks of ChunkBits addresses, so that a store keeps each one in a key and
+// only writes the chunk an allocation changes. Missing chunks are empty.
+type IPBitmap struct {
+	Subnet *net.IPNet
+	Chunks map[uint64][]byte
+}
+
+// NewIPBitmap returns an empty index of subnet.
+func NewIPBitmap(subnet *net.IPNet) *IPBitmap {
+	network := &net.IPNet{IP: subnet.IP.Mask(subnet.Mask), Mask: subnet.Mask}
+	return &IPBitmap{Subnet: network, Chunks: map[uint64][]byte{}}
+}
+
+// Locate returns the chunk holding addr and its bit in the chunk, false if
+// addr isn't in the subnet.
+func (b *IPBitmap) Locate(addr net.IP) (uint64, uint, bool) {
+	if !b.Subnet.Contains(addr) {
+		return 0, 0, false
+	}
+	network, ip := b.Subnet.IP.To16(), addr.To16()
+	offset := new(big.Int).Sub(new(big.Int).SetBytes(ip), new(big.Int).SetBytes(network))
+	bit := new(big.Int)
+	chunk, _ := offset.DivMod(offset, big.NewInt(ChunkBits), bit)
+	// Only subnets up to 2^76 addresses, such as IPv6 /52, can be indexed.
+	if !chunk.IsUint64() {
+		return 0, 0, false
+	}
+	return chunk.Uint64(), uint(bit.Uint64()), true
+}
+
+// Has tells whether addr is allocated.
+func (b *IPBitmap) Has(addr net.IP) bool {
+	chunk, bit, ok := b.Locate(addr)
+	if !ok {
+		return false
+	}
+	data := b.Chunks[chunk]
+	return len(data) > int(bit/8) && data[bit/8]&(1<<(bit%8)) != 0
+}
+
+// Set marks addr allocated, or free. It returns the chunk it changed, false
+// if addr isn't in the subnet.
+func (b *IPBitmap) Set(addr net.IP, allocated bool) (uint64, bool) {
+	chunk, bit, ok := b.Locate(addr)
+	if !ok {
+		return 0, false
+	}
+	data := b.Chunks[chunk]
+	if len(data) < ChunkBits/8 {
+		data = append(data, make([]byte, ChunkBits/8-len(data))...)
+		b.Chunks[chunk] = data
+	}
+	if allocated {
+		data[bit/8] |= 1 << (bit % 8)
+	} else {
+		data[bit/8] &^= 1 << (bit % 8)
+	}
+	return chunk, true
+}
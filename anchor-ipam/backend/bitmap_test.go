@@ -0,0 +1,74 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IP bitmap", func() {
+	subnet := func(s string) *net.IPNet {
+		_, n, err := net.ParseCIDR(s)
+		Expect(err).NotTo(HaveOccurred())
+		return n
+	}
+
+	It("locates addresses from the network address", func() {
+		b := NewIPBitmap(subnet("10.0.0.0/16"))
+		chunk, bit, ok := b.Locate(net.ParseIP("10.0.0.5"))
+		Expect(ok).To(BeTrue())
+		Expect(chunk).To(Equal(uint64(0)))
+		Expect(bit).To(Equal(uint(5)))
+
+		// 10.0.16.1 is the address 16*256+1 of the subnet.
+		chunk, bit, ok = b.Locate(net.ParseIP("10.0.16.1"))
+		Expect(ok).To(BeTrue())
+		Expect(chunk).To(Equal(uint64(1)))
+		Expect(bit).To(Equal(uint(1)))
+
+		_, _, ok = b.Locate(net.ParseIP("10.1.0.1"))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("marks addresses allocated and free", func() {
+		b := NewIPBitmap(subnet("10.0.0.0/16"))
+		addr := net.ParseIP("10.0.200.7")
+		Expect(b.Has(addr)).To(BeFalse())
+
+		chunk, ok := b.Set(addr, true)
+		Expect(ok).To(BeTrue())
+		Expect(b.Chunks[chunk]).To(HaveLen(ChunkBits / 8))
+		Expect(b.Has(addr)).To(BeTrue())
+		Expect(b.Has(net.ParseIP("10.0.200.6"))).To(BeFalse())
+		Expect(b.Chunks).To(HaveLen(1))
+
+		b.Set(addr, false)
+		Expect(b.Has(addr)).To(BeFalse())
+
+		_, ok = b.Set(net.ParseIP("10.1.0.1"), true)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("indexes IPv6 subnets", func() {
+		b := NewIPBitmap(subnet("2001:db8::/64"))
+		addr := net.ParseIP("2001:db8::ffff:ffff:ffff")
+		b.Set(addr, true)
+		Expect(b.Has(addr)).To(BeTrue())
+		Expect(b.Has(net.ParseIP("2001:db8::1"))).To(BeFalse())
+	})
+})
@@ -16,35 +16,56 @@ package etcd
 
 import (
 	"context"
-	"fmt"
 	"crypto/tls"
-	"github.com/containernetworking/cni/pkg/types"
-	"github.com/daocloud/anchor/anchor-ipam/backend"
+	"fmt"
 	"net"
 	"strings"
 	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
 	"github.com/coreos/etcd/clientv3"
 	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/coreos/etcd/clientv3/namespace"
+	"github.com/daocloud/anchor/anchor-ipam/backend"
 )
 
+// The keys are relative to the prefix of the network, such as /anchor/.
 const (
-	ipsPrefix = "/anchor/ips/"
-	gatewayPrefix = "/anchor/gw/"
-	userPrefix = "/anchor/user/"
-	lockKey = "/anchor/lock"
+	ipsPrefix              = "ips/"
+	gatewayPrefix          = "gw/"
+	userPrefix             = "user/"
+	externalPrefix         = "external/"
+	subnetExclusionsPrefix = "exclude/subnet/"
+	poolExclusionsPrefix   = "exclude/pool/"
+	lockKey                = "lock"
 )
 
 // Store is a simple etcd-backed store that creates one kv pair per IP
 // address. The value of the pair is the container ID.
 type Store struct {
-	mutex *concurrency.Mutex
-	kv    clientv3.KV
+	client *clientv3.Client
+	// The session holding the lock, replaced once its lease is lost, such
+	// as when etcd was unreachable for longer than its TTL.
+	session *concurrency.Session
+	mutex   *concurrency.Mutex
+	kv      clientv3.KV
+	lease   clientv3.Lease
+	// Node is recorded in the history of the addresses reserved, it may
+	// be empty.
+	Node string
+	// HistoryRetention is how long the history of a released address is
+	// kept.
+	HistoryRetention time.Duration
+	// PendingTTL is how long a reservation waits for its commit.
+	PendingTTL time.Duration
 }
 
 // Store implements the Store interface
 var _ backend.Store = &Store{}
 
-func New(network string, endPoints []string, tlsConfig *tls.Config) (*Store, error) {
+// New connects to etcd, keeping every key of the store, its lock included,
+// under prefix.
+func New(prefix string, endPoints []string, tlsConfig *tls.Config) (*Store, error) {
 	if len(endPoints) == 0 {
 		return nil, fmt.Errorf("No available endpoints for etcd client")
 	}
@@ -58,19 +79,46 @@ func New(network string, endPoints []string, tlsConfig *tls.Config) (*Store, err
 	if err != nil {
 		return nil, err
 	}
+	// The lock watches and leases its keys through the client too.
+	cli.KV = namespace.NewKV(cli.KV, prefix)
+	cli.Watcher = namespace.NewWatcher(cli.Watcher, prefix)
+	cli.Lease = namespace.NewLease(cli.Lease, prefix)
 	// TODO: No, this will give you a bug.
 	// defer cli.Close()
-	session, err := concurrency.NewSession(cli)
-	if err != nil {
+	s := &Store{
+		client:           cli,
+		kv:               cli.KV,
+		HistoryRetention: backend.DefaultHistoryRetention,
+		PendingTTL:       DefaultPendingTTL,
+		lease:            cli.Lease,
+	}
+	if err := s.newSession(); err != nil {
 		return nil, err
 	}
+	return s, nil
+}
 
-	mutex := concurrency.NewMutex(session, lockKey)
-	kv := clientv3.NewKV(cli)
-	return &Store{mutex, kv}, nil
+func (s *Store) newSession() error {
+	session, err := concurrency.NewSession(s.client)
+	if err != nil {
+		return err
+	}
+	s.session = session
+	s.mutex = concurrency.NewMutex(session, lockKey)
+	return nil
 }
 
+// Lock starts a new session first if the previous one is lost, so that a
+// long-lived store, such as that of the agent, keeps working once etcd is
+// back.
 func (s *Store) Lock() error {
+	select {
+	case <-s.session.Done():
+		if err := s.newSession(); err != nil {
+			return fmt.Errorf("failed to renew the etcd session: %v", err)
+		}
+	default:
+	}
 	return s.mutex.Lock(context.TODO())
 }
 
@@ -84,12 +132,12 @@ func (s *Store) Close() error {
 }
 
 func (s *Store) GetAllocatedIPs(namespace string) (string, error) {
-	resp, err := s.kv.Get(context.TODO(), userPrefix + namespace)
+	resp, err := s.kv.Get(context.TODO(), userPrefix+namespace)
 	if err != nil {
 		return "", err
 	}
 	if len(resp.Kvs) == 0 {
-		return "", fmt.Errorf("Namespace %s not found in etcd", namespace)
+		return "", backend.ErrNotFound(fmt.Sprintf("Namespace %s not found in etcd", namespace))
 	}
 	return string(resp.Kvs[0].Value), nil
 
@@ -103,7 +151,7 @@ func (s *Store) GetGatewayForIP(ip net.IP) (*net.IPNet, *net.IP, error) {
 	}
 
 	if len(resp.Kvs) == 0 {
-		return nil, nil, fmt.Errorf("Gateway not found for %s", ip.String())
+		return nil, nil, backend.ErrNotFound(fmt.Sprintf("Gateway not found for %s", ip.String()))
 	}
 
 	for _, item := range resp.Kvs {
@@ -122,99 +170,319 @@ func (s *Store) GetGatewayForIP(ip net.IP) (*net.IPNet, *net.IP, error) {
 
 		}
 	}
-	return nil, nil, fmt.Errorf("Not subnet found for IP %s", ip.String())
+	return nil, nil, backend.ErrNotFound(fmt.Sprintf("Not subnet found for IP %s", ip.String()))
+}
+
+// formatAllocation returns the value of an allocation key, which is
+// ip,pod,namespace,app,service, followed by the tenant unless it is the
+// namespace.
+func formatAllocation(a *backend.Allocation) string {
+	value := a.IP.String() + "," + a.Pod + "," + a.Namespace + "," + a.App + "," + a.Service
+	if a.Tenant != "" && a.Tenant != a.Namespace {
+		value += "," + a.Tenant
+	}
+	return value
 }
 
+// parseAllocation parses the value of an allocation key.
+func parseAllocation(key, value string) (*backend.Allocation, error) {
+	row := strings.Split(strings.TrimSpace(value), ",")
+	if len(row) != 5 && len(row) != 6 {
+		return nil, fmt.Errorf("invalid allocation %s: %q", key, value)
+	}
+	ip := net.ParseIP(row[0])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP in allocation %s: %q", key, value)
+	}
+	a := &backend.Allocation{
+		ID:        strings.TrimPrefix(key, ipsPrefix),
+		IP:        ip,
+		Pod:       row[1],
+		Namespace: row[2],
+		App:       row[3],
+		Service:   row[4],
+	}
+	if len(row) == 6 {
+		a.Tenant = row[5]
+	}
+	return a, nil
+}
 
-// GetUsedByPod it is a bug, should be removed later.
-func (s *Store) GetUsedByPod(pod string, namespace string) ([]net.IP, error) {
+// ListAllocations skips the keys under ipsPrefix that aren't allocations.
+func (s *Store) ListAllocations() ([]*backend.Allocation, error) {
 	resp, err := s.kv.Get(context.TODO(), ipsPrefix, clientv3.WithPrefix())
 	if err != nil {
 		return nil, err
 	}
-	ret := make([]net.IP, 0)
-
+	ret := make([]*backend.Allocation, 0, len(resp.Kvs))
 	for _, item := range resp.Kvs {
-		// TODO: will bug if someone insert something to ipsPrefix ancidently.
-		row := strings.Split(string(item.Value), ",")
-		if row[2] == pod && row[3] == namespace {
-			ret = append(ret, net.ParseIP(row[1]))
-
+		a, err := parseAllocation(string(item.Key), string(item.Value))
+		if err != nil {
+			continue
 		}
+		ret = append(ret, a)
 	}
 	return ret, nil
 }
 
-// GetUsedBySvc
-func (s *Store) GetUsedBySvc(app string, svc string) ([]net.IP, error) {
-	resp, err := s.kv.Get(context.TODO(), ipsPrefix, clientv3.WithPrefix())
+func (s *Store) ListPools() (map[string]string, error) {
+	resp, err := s.kv.Get(context.TODO(), userPrefix, clientv3.WithPrefix())
 	if err != nil {
 		return nil, err
 	}
-	ret := make([]net.IP, 0)
-
+	ret := make(map[string]string, len(resp.Kvs))
 	for _, item := range resp.Kvs {
-		// TODO: will bug if someone insert something to ipsPrefix ancidently.
-		row := strings.Split(string(item.Value), ",")
-		if row[3] == app && row[4] == svc {
-			ret = append(ret, net.ParseIP(row[0]))
-
-		}
+		ret[strings.TrimPrefix(string(item.Key), userPrefix)] = string(item.Value)
 	}
 	return ret, nil
 }
 
-// GetUsedIPbyNamespace
-func (s *Store) GetUsedIPbyNamespace(namespace string) ([]net.IP, error) {
-	resp, err := s.kv.Get(context.TODO(), ipsPrefix, clientv3.WithPrefix())
+func (s *Store) ListGateways() ([]*backend.Gateway, error) {
+	resp, err := s.kv.Get(context.TODO(), gatewayPrefix, clientv3.WithPrefix())
 	if err != nil {
 		return nil, err
 	}
-	ret := make([]net.IP, 0)
-
+	ret := make([]*backend.Gateway, 0, len(resp.Kvs))
 	for _, item := range resp.Kvs {
-		// TODO: will bug if someone insert something to ipsPrefix ancidently.
-		row := strings.Split(string(item.Value), ",")
-		if row[2] == namespace {
-			ret = append(ret, net.ParseIP(row[0]))
+		x := strings.Split(string(item.Value), ",")
+		if len(x) != 2 {
+			continue
+		}
+		subnet, err := types.ParseCIDR(x[0])
+		if err != nil {
+			continue
+		}
+		ret = append(ret, &backend.Gateway{Subnet: subnet, Gateway: net.ParseIP(x[1])})
+	}
+	return ret, nil
+}
+
+func (s *Store) SetPool(namespace string, ranges string) error {
+	_, err := s.kv.Put(context.TODO(), userPrefix+namespace, ranges)
+	return err
+}
+
+func (s *Store) DeletePool(namespace string) error {
+	_, err := s.kv.Delete(context.TODO(), userPrefix+namespace)
+	return err
+}
 
+// TransferPool writes the pools, their exclusions, the allocations and the
+// holds in a single transaction, which etcd refuses past its --max-txn-ops.
+func (s *Store) TransferPool(t *backend.PoolTransfer) error {
+	ops := []clientv3.Op{
+		putOrDelete(userPrefix+t.To, t.ToPool),
+		putOrDelete(userPrefix+t.From, t.FromPool),
+		putOrDelete(poolExclusionsPrefix+t.To, t.ToExclusions),
+		putOrDelete(poolExclusionsPrefix+t.From, t.FromExclusions),
+	}
+	for _, a := range t.Allocations {
+		moved := *a
+		moved.Tenant = t.To
+		ops = append(ops,
+			clientv3.OpPut(ipsPrefix+a.ID, formatAllocation(&moved)),
+			clientv3.OpDelete(byOwnerKey(a)),
+			clientv3.OpPut(byOwnerKey(&moved), a.IP.String()))
+	}
+	for _, h := range t.Holds {
+		holdOps, err := s.holdOps(h)
+		if err != nil {
+			return err
 		}
+		ops = append(ops, holdOps...)
+	}
+	_, err := s.kv.Txn(context.TODO()).Then(ops...).Commit()
+	return err
+}
+
+// putOrDelete returns the write of key, or its deletion when value is empty.
+func putOrDelete(key, value string) clientv3.Op {
+	if value == "" {
+		return clientv3.OpDelete(key)
+	}
+	return clientv3.OpPut(key, value)
+}
+
+// SetGateway keys the gateway by its subnet, as the governor does. The
+// allocations of the subnet aren't indexed yet, so the index is rebuilt on
+// its next use.
+func (s *Store) SetGateway(subnet *net.IPNet, gateway net.IP) error {
+	_, err := s.kv.Txn(context.TODO()).Then(
+		clientv3.OpPut(gatewayPrefix+subnet.String(), subnet.String()+","+gateway.String()),
+		clientv3.OpDelete(indexReadyKey),
+	).Commit()
+	return err
+}
+
+func (s *Store) DeleteGateway(subnet *net.IPNet) error {
+	_, err := s.kv.Txn(context.TODO()).Then(
+		clientv3.OpDelete(gatewayPrefix+subnet.String()),
+		clientv3.OpDelete(indexReadyKey),
+	).Commit()
+	return err
+}
+
+func (s *Store) MarkExternal(ip net.IP, note string) error {
+	_, err := s.kv.Txn(context.TODO()).Then(
+		clientv3.OpPut(externalPrefix+ip.String(), note),
+		clientv3.OpPut(unavailableKey(ip, unavailableExternal), ""),
+	).Commit()
+	return err
+}
+
+func (s *Store) ClearExternal(ip net.IP) error {
+	_, err := s.kv.Txn(context.TODO()).Then(
+		clientv3.OpDelete(externalPrefix+ip.String()),
+		clientv3.OpDelete(unavailableKey(ip, unavailableExternal)),
+	).Commit()
+	return err
+}
+
+func (s *Store) ListExternal() (map[string]string, error) {
+	resp, err := s.kv.Get(context.TODO(), externalPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]string, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		ret[strings.TrimPrefix(string(item.Key), externalPrefix)] = string(item.Value)
 	}
 	return ret, nil
 }
 
-func (s *Store) Reserve(id string, ip net.IP, podName string, podNamespace string, app string, service string) (bool, error) {
-	// TODO: lock
-	if _, err := s.kv.Put(context.TODO(), ipsPrefix + id,
-		ip.String()+ "," + podName + "," + podNamespace + "," + app + "," + service); err != nil {
-		return false, nil
+func (s *Store) ListExclusions() (*backend.Exclusions, error) {
+	subnets, err := s.kv.Get(context.TODO(), subnetExclusionsPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	pools, err := s.kv.Get(context.TODO(), poolExclusionsPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	ret := &backend.Exclusions{
+		Subnets: make(map[string]string, len(subnets.Kvs)),
+		Pools:   make(map[string]string, len(pools.Kvs)),
+	}
+	for _, item := range subnets.Kvs {
+		ret.Subnets[strings.TrimPrefix(string(item.Key), subnetExclusionsPrefix)] = string(item.Value)
 	}
+	for _, item := range pools.Kvs {
+		ret.Pools[strings.TrimPrefix(string(item.Key), poolExclusionsPrefix)] = string(item.Value)
+	}
+	return ret, nil
+}
+
+func (s *Store) SetSubnetExclusions(subnet *net.IPNet, ranges string) error {
+	_, err := s.kv.Put(context.TODO(), subnetExclusionsPrefix+subnet.String(), ranges)
+	return err
+}
+
+func (s *Store) DeleteSubnetExclusions(subnet *net.IPNet) error {
+	_, err := s.kv.Delete(context.TODO(), subnetExclusionsPrefix+subnet.String())
+	return err
+}
+
+func (s *Store) SetPoolExclusions(namespace string, ranges string) error {
+	_, err := s.kv.Put(context.TODO(), poolExclusionsPrefix+namespace, ranges)
+	return err
+}
 
+func (s *Store) DeletePoolExclusions(namespace string) error {
+	_, err := s.kv.Delete(context.TODO(), poolExclusionsPrefix+namespace)
+	return err
+}
+
+// Reserve writes a pending reservation, which expires after PendingTTL
+// unless committed, along with its keys under byOwnerPrefix and
+// unavailablePrefix.
+func (s *Store) Reserve(id string, ip net.IP, podName string, podNamespace string, app string, service string, tenant string) (bool, error) {
+	a := &backend.Allocation{ID: id, IP: ip, Pod: podName, Namespace: podNamespace, App: app, Service: service, Tenant: tenant}
+	value := formatAllocation(a)
+	ttl := int64(s.PendingTTL / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+	lease, err := s.lease.Grant(context.TODO(), ttl)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.kv.Txn(context.TODO()).Then(
+		clientv3.OpPut(pendingPrefix+id, value, clientv3.WithLease(lease.ID)),
+		clientv3.OpPut(byOwnerKey(a), ip.String(), clientv3.WithLease(lease.ID)),
+		clientv3.OpPut(unavailableKey(ip, unavailablePending), id, clientv3.WithLease(lease.ID)),
+	).Commit()
+	if err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
+func (s *Store) GetByID(id string) (net.IP, error) {
+	resp, err := s.kv.Get(context.TODO(), ipsPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	return net.ParseIP(strings.Split(string(resp.Kvs[0].Value), ",")[0]), nil
+}
+
+// Release deletes the allocation of id, frees its address in the index and
+// ends its history. An address is expected to be held by a single container.
+// A reservation not committed yet is just deleted.
 func (s *Store) Release(id string) error {
-	_, err := s.kv.Delete(context.TODO(), ipsPrefix + id)
-	return err
+	if err := s.releasePending(id); err != nil {
+		return err
+	}
+	resp, err := s.kv.Get(context.TODO(), ipsPrefix+id)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil
+	}
+	a, err := parseAllocation(string(resp.Kvs[0].Key), string(resp.Kvs[0].Value))
+	if err != nil {
+		// Not indexed either.
+		_, err = s.kv.Delete(context.TODO(), ipsPrefix+id)
+		return err
+	}
+	historyOps, err := s.releaseHistoryOps(a)
+	if err != nil {
+		return err
+	}
+	ops := append([]clientv3.Op{
+		clientv3.OpDelete(ipsPrefix + id),
+		clientv3.OpDelete(byIPPrefix + a.IP.String()),
+	}, lookupOps(a, true)...)
+	return s.commitIndexed(a.IP, false, append(ops, historyOps...)...)
 }
 
-// N.B. This function eats errors to be tolerant and
-// release as much as possible
+// ReleaseByIP finds the container holding ip by its key in byIPPrefix,
+// falling back to a scan of the allocations made before the index.
 func (s *Store) ReleaseByIP(ip net.IP) error {
-	resp, err := s.kv.Get(context.TODO(), ipsPrefix, clientv3.WithPrefix())
+	resp, err := s.kv.Get(context.TODO(), byIPPrefix+ip.String())
 	if err != nil {
 		return err
 	}
+	if len(resp.Kvs) > 0 {
+		return s.Release(string(resp.Kvs[0].Value))
+	}
 
-	if len(resp.Kvs) == 0 {
+	allocs, err := s.ListAllocations()
+	if err != nil {
+		return err
+	}
+
+	if len(allocs) == 0 {
 		// TODO: improve.
 		return fmt.Errorf("No value in %s", ipsPrefix)
 	}
 
-	for _, item := range resp.Kvs {
-		if strings.Split(strings.TrimSpace(string(item.Value)), ",")[1] == strings.TrimSpace(ip.String()) {
-			_, err = s.kv.Delete(context.TODO(), strings.TrimSpace(string(item.Key)))
-			if err != nil {
+	for _, a := range allocs {
+		if a.IP.Equal(ip) {
+			if err := s.Release(a.ID); err != nil {
 				return err
 			}
 		}
@@ -0,0 +1,182 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+// The containers that held an address are kept in history/<ip>/<container id>,
+// as pod,namespace,app,service,node,start,end with RFC 3339 times, end being
+// empty while the address is held.
+const historyPrefix = "history/"
+
+// Most expired entries of an address deleted when it is released, the
+// others are left to PruneHistory.
+const maxPrunedOnRelease = 64
+
+func historyKey(ip net.IP, id string) string {
+	return historyPrefix + ip.String() + "/" + id
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func formatHistory(e *backend.HistoryEntry) string {
+	return strings.Join([]string{e.Pod, e.Namespace, e.App, e.Service, e.Node, formatTime(e.Start), formatTime(e.End)}, ",")
+}
+
+func parseHistory(key, value string) (*backend.HistoryEntry, error) {
+	parts := strings.SplitN(strings.TrimPrefix(key, historyPrefix), "/", 2)
+	row := strings.Split(value, ",")
+	if len(parts) != 2 || len(row) != 7 {
+		return nil, fmt.Errorf("invalid history entry %s: %q", key, value)
+	}
+	ip := net.ParseIP(parts[0])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP in history entry %s", key)
+	}
+	start, err := parseTime(row[5])
+	if err != nil {
+		return nil, fmt.Errorf("invalid start of history entry %s: %v", key, err)
+	}
+	end, err := parseTime(row[6])
+	if err != nil {
+		return nil, fmt.Errorf("invalid end of history entry %s: %v", key, err)
+	}
+	return &backend.HistoryEntry{
+		ID:        parts[1],
+		IP:        ip,
+		Pod:       row[0],
+		Namespace: row[1],
+		App:       row[2],
+		Service:   row[3],
+		Node:      row[4],
+		Start:     start,
+		End:       end,
+	}, nil
+}
+
+// listHistory returns the entries under prefix, skipping the invalid ones.
+func (s *Store) listHistory(prefix string) ([]*backend.HistoryEntry, error) {
+	resp, err := s.kv.Get(context.TODO(), prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*backend.HistoryEntry, 0, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		e, err := parseHistory(string(item.Key), string(item.Value))
+		if err != nil {
+			continue
+		}
+		ret = append(ret, e)
+	}
+	backend.SortHistory(ret)
+	return ret, nil
+}
+
+func (s *Store) GetHistory(ip net.IP) ([]*backend.HistoryEntry, error) {
+	return s.listHistory(historyPrefix + ip.String() + "/")
+}
+
+func (s *Store) ListHistory() ([]*backend.HistoryEntry, error) {
+	return s.listHistory(historyPrefix)
+}
+
+// PruneHistory deletes in several transactions, entries are independent.
+func (s *Store) PruneHistory(before time.Time) (int, error) {
+	entries, err := s.ListHistory()
+	if err != nil {
+		return 0, err
+	}
+	ops := []clientv3.Op{}
+	for _, e := range entries {
+		if e.Expired(before) {
+			ops = append(ops, clientv3.OpDelete(historyKey(e.IP, e.ID)))
+		}
+	}
+	pruned := 0
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > maxTxnOps {
+			n = maxTxnOps
+		}
+		if _, err := s.kv.Txn(context.TODO()).Then(ops[:n]...).Commit(); err != nil {
+			return pruned, err
+		}
+		pruned += n
+		ops = ops[n:]
+	}
+	return pruned, nil
+}
+
+// reserveHistoryOp starts the history entry of an allocation.
+func (s *Store) reserveHistoryOp(a *backend.Allocation) clientv3.Op {
+	e := &backend.HistoryEntry{
+		Pod:       a.Pod,
+		Namespace: a.Namespace,
+		App:       a.App,
+		Service:   a.Service,
+		Node:      s.Node,
+		Start:     time.Now(),
+	}
+	return clientv3.OpPut(historyKey(a.IP, a.ID), formatHistory(e))
+}
+
+// releaseHistoryOps ends the history entry of an allocation, and deletes
+// the entries of its address past the retention.
+func (s *Store) releaseHistoryOps(a *backend.Allocation) ([]clientv3.Op, error) {
+	entries, err := s.GetHistory(a.IP)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	// Allocations made before the history have no entry yet.
+	current := &backend.HistoryEntry{
+		Pod:       a.Pod,
+		Namespace: a.Namespace,
+		App:       a.App,
+		Service:   a.Service,
+	}
+	ops := []clientv3.Op{}
+	for _, e := range entries {
+		switch {
+		case e.ID == a.ID:
+			current = e
+		case e.Expired(now.Add(-s.HistoryRetention)) && len(ops) < maxPrunedOnRelease:
+			ops = append(ops, clientv3.OpDelete(historyKey(e.IP, e.ID)))
+		}
+	}
+	current.End = now
+	return append(ops, clientv3.OpPut(historyKey(a.IP, a.ID), formatHistory(current))), nil
+}
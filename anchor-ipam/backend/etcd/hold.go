@@ -0,0 +1,106 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+// The holds are kept in hold/<ip>, as namespace,service,expires
+// with an RFC 3339 time, empty if the hold doesn't expire, and looked up by
+// subnet under unavailablePrefix. Holds that expire are attached to a lease,
+// so that etcd deletes them.
+const holdPrefix = "hold/"
+
+func (s *Store) SetHold(hold *backend.Hold) error {
+	ops, err := s.holdOps(hold)
+	if err != nil {
+		return err
+	}
+	_, err = s.kv.Txn(context.TODO()).Then(ops...).Commit()
+	return err
+}
+
+// holdOps returns the writes of a hold, with a new lease if it expires.
+func (s *Store) holdOps(hold *backend.Hold) ([]clientv3.Op, error) {
+	if strings.Contains(hold.Namespace+hold.Service, ",") {
+		return nil, fmt.Errorf("invalid workload %s/%s", hold.Namespace, hold.Service)
+	}
+	value := hold.Namespace + "," + hold.Service + "," + formatTime(hold.Expires)
+	opts := []clientv3.OpOption{}
+	if !hold.Expires.IsZero() {
+		ttl := int64(time.Until(hold.Expires) / time.Second)
+		if ttl < 1 {
+			return nil, fmt.Errorf("the hold of %s expires in the past", hold.IP)
+		}
+		lease, err := s.lease.Grant(context.TODO(), ttl)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, clientv3.WithLease(lease.ID))
+	}
+	return []clientv3.Op{
+		clientv3.OpPut(holdPrefix+hold.IP.String(), value, opts...),
+		clientv3.OpPut(unavailableKey(hold.IP, unavailableHold), value, opts...),
+	}, nil
+}
+
+func (s *Store) DeleteHold(ip net.IP) error {
+	_, err := s.kv.Txn(context.TODO()).Then(
+		clientv3.OpDelete(holdPrefix+ip.String()),
+		clientv3.OpDelete(unavailableKey(ip, unavailableHold)),
+	).Commit()
+	return err
+}
+
+// ListHolds skips the keys under holdPrefix that aren't holds.
+func (s *Store) ListHolds() ([]*backend.Hold, error) {
+	resp, err := s.kv.Get(context.TODO(), holdPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*backend.Hold, 0, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		ip := net.ParseIP(strings.TrimPrefix(string(item.Key), holdPrefix))
+		if ip == nil {
+			continue
+		}
+		h, err := parseHold(ip, string(item.Value))
+		if err != nil {
+			continue
+		}
+		ret = append(ret, h)
+	}
+	return ret, nil
+}
+
+func parseHold(ip net.IP, value string) (*backend.Hold, error) {
+	row := strings.Split(value, ",")
+	if len(row) != 3 {
+		return nil, fmt.Errorf("invalid hold of %s: %q", ip, value)
+	}
+	expires, err := parseTime(row[2])
+	if err != nil {
+		return nil, err
+	}
+	return &backend.Hold{IP: ip, Namespace: row[0], Service: row[1], Expires: expires}, nil
+}
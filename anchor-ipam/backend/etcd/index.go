@@ -0,0 +1,245 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+const (
+	// The chunks of the index of a subnet are kept in index/<subnet>/<chunk>.
+	indexPrefix = "index/"
+	// The container holding an address is kept in byip/<ip>.
+	byIPPrefix = "byip/"
+	// indexReadyKey exists once the index, and the keys of lookup.go,
+	// match the allocations. It was index-ready before those keys.
+	indexReadyKey = "index-ready-v2"
+
+	// Most operations etcd accepts in a transaction by default.
+	maxTxnOps = 128
+	// Attempts of an update of the index racing with another one.
+	maxTxnAttempts = 5
+)
+
+func subnetIndexPrefix(subnet *net.IPNet) string {
+	return indexPrefix + subnet.String() + "/"
+}
+
+func chunkKey(subnet *net.IPNet, chunk uint64) string {
+	return subnetIndexPrefix(subnet) + strconv.FormatUint(chunk, 10)
+}
+
+// GetIndex returns the allocation index of subnet, rebuilding the index of
+// every subnet first when it isn't ready.
+func (s *Store) GetIndex(subnet *net.IPNet) (*backend.IPBitmap, error) {
+	if err := s.ensureIndex(); err != nil {
+		return nil, err
+	}
+
+	b := backend.NewIPBitmap(subnet)
+	prefix := subnetIndexPrefix(b.Subnet)
+	resp, err := s.kv.Get(context.TODO(), prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range resp.Kvs {
+		chunk, err := strconv.ParseUint(strings.TrimPrefix(string(item.Key), prefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		b.Chunks[chunk] = item.Value
+	}
+	return b, nil
+}
+
+func (s *Store) indexReady() (bool, error) {
+	resp, err := s.kv.Get(context.TODO(), indexReadyKey, clientv3.WithCountOnly())
+	if err != nil {
+		return false, err
+	}
+	return resp.Count > 0, nil
+}
+
+// ensureIndex rebuilds the index unless it is ready, the store must be
+// locked.
+func (s *Store) ensureIndex() error {
+	ready, err := s.indexReady()
+	if err != nil || ready {
+		return err
+	}
+	if err := s.RebuildIndex(); err != nil {
+		return fmt.Errorf("failed to rebuild the allocation index: %v", err)
+	}
+	return nil
+}
+
+// RebuildIndex rebuilds the index of every registered subnet, the addresses
+// by IP and the keys of lookup.go from the allocations, the reservations,
+// the holds and the external addresses. It is written in several
+// transactions, so the store must be locked.
+func (s *Store) RebuildIndex() error {
+	allocs, err := s.ListAllocations()
+	if err != nil {
+		return err
+	}
+	gateways, err := s.ListGateways()
+	if err != nil {
+		return err
+	}
+
+	bitmaps := make([]*backend.IPBitmap, 0, len(gateways))
+	for _, gw := range gateways {
+		bitmaps = append(bitmaps, backend.NewIPBitmap(gw.Subnet))
+	}
+	unavailable, err := s.unavailableOps()
+	if err != nil {
+		return err
+	}
+	ops := []clientv3.Op{clientv3.OpDelete(indexReadyKey)}
+	for _, prefix := range []string{indexPrefix, byIPPrefix, byOwnerPrefix, byPodPrefix, byServicePrefix, unavailablePrefix} {
+		ops = append(ops, clientv3.OpDelete(prefix, clientv3.WithPrefix()))
+	}
+	ops = append(ops, unavailable...)
+	for _, a := range allocs {
+		ops = append(ops, clientv3.OpPut(byIPPrefix+a.IP.String(), a.ID))
+		ops = append(ops, lookupOps(a, false)...)
+		for _, b := range bitmaps {
+			if _, ok := b.Set(a.IP, true); ok {
+				break
+			}
+		}
+	}
+	for _, b := range bitmaps {
+		for chunk, data := range b.Chunks {
+			ops = append(ops, clientv3.OpPut(chunkKey(b.Subnet, chunk), string(data)))
+		}
+	}
+	// Last, so that the index is only used once complete.
+	ops = append(ops, clientv3.OpPut(indexReadyKey, ""))
+
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > maxTxnOps {
+			n = maxTxnOps
+		}
+		if _, err := s.kv.Txn(context.TODO()).Then(ops[:n]...).Commit(); err != nil {
+			return err
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+// unavailableOps returns the keys under unavailablePrefix, and those of the
+// reservations under byOwnerPrefix, attached to the leases of the keys they
+// are made from.
+func (s *Store) unavailableOps() ([]clientv3.Op, error) {
+	ops := []clientv3.Op{}
+	for _, prefix := range []string{pendingPrefix, holdPrefix, externalPrefix} {
+		resp, err := s.kv.Get(context.TODO(), prefix, clientv3.WithPrefix())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range resp.Kvs {
+			key := strings.TrimPrefix(string(item.Key), prefix)
+			opts := []clientv3.OpOption{}
+			if item.Lease != 0 {
+				opts = append(opts, clientv3.WithLease(clientv3.LeaseID(item.Lease)))
+			}
+			switch prefix {
+			case pendingPrefix:
+				a, err := parseAllocation(string(item.Key), string(item.Value))
+				if err != nil {
+					continue
+				}
+				a.ID = key
+				ops = append(ops,
+					clientv3.OpPut(unavailableKey(a.IP, unavailablePending), a.ID, opts...),
+					clientv3.OpPut(byOwnerKey(a), a.IP.String(), opts...))
+			case holdPrefix:
+				if ip := net.ParseIP(key); ip != nil {
+					ops = append(ops, clientv3.OpPut(unavailableKey(ip, unavailableHold), string(item.Value), opts...))
+				}
+			case externalPrefix:
+				if ip := net.ParseIP(key); ip != nil {
+					ops = append(ops, clientv3.OpPut(unavailableKey(ip, unavailableExternal), "", opts...))
+				}
+			}
+		}
+	}
+	return ops, nil
+}
+
+// indexOps returns the update of the index marking addr allocated or free,
+// which only applies if its chunk didn't change since it was read. Addresses
+// of no registered subnet aren't indexed.
+func (s *Store) indexOps(addr net.IP, allocated bool) ([]clientv3.Cmp, []clientv3.Op, error) {
+	subnet, _, err := s.GetGatewayForIP(addr)
+	if _, ok := err.(backend.ErrNotFound); ok {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b := backend.NewIPBitmap(subnet)
+	chunk, _, ok := b.Locate(addr)
+	if !ok {
+		return nil, nil, nil
+	}
+	key := chunkKey(b.Subnet, chunk)
+	resp, err := s.kv.Get(context.TODO(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	// A missing key has the revision 0.
+	var rev int64
+	if len(resp.Kvs) > 0 {
+		b.Chunks[chunk] = resp.Kvs[0].Value
+		rev = resp.Kvs[0].ModRevision
+	}
+	b.Set(addr, allocated)
+
+	cmps := []clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(key), "=", rev)}
+	ops := []clientv3.Op{clientv3.OpPut(key, string(b.Chunks[chunk]))}
+	return cmps, ops, nil
+}
+
+// commitIndexed applies ops, along with the update of the index for addr,
+// retrying when another update of its chunk came first.
+func (s *Store) commitIndexed(addr net.IP, allocated bool, ops ...clientv3.Op) error {
+	for attempt := 0; attempt < maxTxnAttempts; attempt++ {
+		cmps, indexOps, err := s.indexOps(addr, allocated)
+		if err != nil {
+			return err
+		}
+		all := append(append([]clientv3.Op{}, ops...), indexOps...)
+		resp, err := s.kv.Txn(context.TODO()).If(cmps...).Then(all...).Commit()
+		if err != nil {
+			return err
+		}
+		if resp.Succeeded {
+			return nil
+		}
+	}
+	return fmt.Errorf("the index of %s kept changing", addr)
+}
@@ -0,0 +1,183 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+// The addresses are looked up by the keys below rather than by a scan of
+// every allocation. Their values are the addresses.
+const (
+	// The allocations and reservations from the pool of a tenant are kept
+	// in by-owner/<owner>/<container id>.
+	byOwnerPrefix = "by-owner/"
+	// The allocations of a pod are kept in by-pod/<namespace>/<pod>/<id>.
+	byPodPrefix = "by-pod/"
+	// The allocations of a service are kept in by-svc/<app>/<service>/<id>.
+	byServicePrefix = "by-svc/"
+	// What can't be allocated besides the allocations is kept in
+	// unavail/<ip>/<kind>, the IP being 32 hexadecimal digits so that the
+	// keys of a subnet are a range. The value of a hold is the one of its
+	// key under holdPrefix.
+	unavailablePrefix = "unavail/"
+
+	unavailablePending  = "pending"
+	unavailableHold     = "hold"
+	unavailableExternal = "external"
+)
+
+func byOwnerKey(a *backend.Allocation) string {
+	return byOwnerPrefix + a.Owner() + "/" + a.ID
+}
+
+func byPodKey(a *backend.Allocation) string {
+	return byPodPrefix + a.Namespace + "/" + a.Pod + "/" + a.ID
+}
+
+func byServiceKey(a *backend.Allocation) string {
+	return byServicePrefix + a.App + "/" + a.Service + "/" + a.ID
+}
+
+// lookupOps writes the keys of an allocation, or deletes them.
+func lookupOps(a *backend.Allocation, deleted bool) []clientv3.Op {
+	keys := []string{byOwnerKey(a), byPodKey(a), byServiceKey(a)}
+	ops := make([]clientv3.Op, 0, len(keys))
+	for _, key := range keys {
+		if deleted {
+			ops = append(ops, clientv3.OpDelete(key))
+		} else {
+			ops = append(ops, clientv3.OpPut(key, a.IP.String()))
+		}
+	}
+	return ops
+}
+
+func ipKey(ip net.IP) string {
+	return hex.EncodeToString(ip.To16())
+}
+
+func unavailableKey(ip net.IP, kind string) string {
+	return unavailablePrefix + ipKey(ip) + "/" + kind
+}
+
+// listLookup returns the addresses of the keys under prefix. Until the
+// index is rebuilt, which needs the lock, it scans the allocations for those
+// that match instead.
+func (s *Store) listLookup(prefix string, match func(a *backend.Allocation) bool) ([]net.IP, error) {
+	ready, err := s.indexReady()
+	if err != nil {
+		return nil, err
+	}
+	if !ready {
+		return s.listUsed(match)
+	}
+	resp, err := s.kv.Get(context.TODO(), prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]net.IP, 0, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		if ip := net.ParseIP(string(item.Value)); ip != nil {
+			ret = append(ret, ip)
+		}
+	}
+	return ret, nil
+}
+
+func (s *Store) listUsed(match func(a *backend.Allocation) bool) ([]net.IP, error) {
+	allocs, err := s.ListAllocations()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]net.IP, 0)
+	for _, a := range allocs {
+		if match(a) {
+			ret = append(ret, a.IP)
+		}
+	}
+	return ret, nil
+}
+
+func (s *Store) GetUsedByPod(pod string, namespace string) ([]net.IP, error) {
+	return s.listLookup(byPodPrefix+namespace+"/"+pod+"/", func(a *backend.Allocation) bool {
+		return a.Pod == pod && a.Namespace == namespace
+	})
+}
+
+func (s *Store) GetUsedBySvc(app string, svc string) ([]net.IP, error) {
+	return s.listLookup(byServicePrefix+app+"/"+svc+"/", func(a *backend.Allocation) bool {
+		return a.App == app && a.Service == svc
+	})
+}
+
+// GetUsedIPbyNamespace includes the reservations not committed yet, once
+// the index is ready.
+func (s *Store) GetUsedIPbyNamespace(namespace string) ([]net.IP, error) {
+	return s.listLookup(byOwnerPrefix+namespace+"/", func(a *backend.Allocation) bool {
+		return a.Owner() == namespace
+	})
+}
+
+// GetUnavailable reads the keys of the subnet under unavailablePrefix,
+// rebuilding them first like GetIndex.
+func (s *Store) GetUnavailable(subnet *net.IPNet) (*backend.Unavailable, error) {
+	if err := s.ensureIndex(); err != nil {
+		return nil, err
+	}
+	first, last := subnet.IP.To16(), make(net.IP, net.IPv6len)
+	mask := subnet.Mask
+	if len(mask) == net.IPv4len {
+		mask = append(net.CIDRMask(96, 128)[:12], mask...)
+	}
+	for i := range last {
+		last[i] = first[i] | ^mask[i]
+	}
+	// "0" sorts after the "/" following the last address.
+	resp, err := s.kv.Get(context.TODO(), unavailablePrefix+ipKey(first), clientv3.WithRange(unavailablePrefix+ipKey(last)+"0"))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &backend.Unavailable{}
+	for _, item := range resp.Kvs {
+		key := strings.Split(strings.TrimPrefix(string(item.Key), unavailablePrefix), "/")
+		if len(key) != 2 {
+			continue
+		}
+		b, err := hex.DecodeString(key[0])
+		if err != nil || len(b) != net.IPv6len {
+			continue
+		}
+		ip := net.IP(b)
+		switch key[1] {
+		case unavailablePending:
+			ret.Pending = append(ret.Pending, ip)
+		case unavailableExternal:
+			ret.External = append(ret.External, ip)
+		case unavailableHold:
+			if h, err := parseHold(ip, string(item.Value)); err == nil {
+				ret.Holds = append(ret.Holds, h)
+			}
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+// Reservations not committed yet are kept in pending/<container id>,
+// in the format of the allocations, attached to a lease.
+const pendingPrefix = "pending/"
+
+// DefaultPendingTTL is how long a reservation waits for its commit, unless
+// configured otherwise. An ADD takes seconds at most.
+const DefaultPendingTTL = 2 * time.Minute
+
+// ListPending skips the keys under pendingPrefix that aren't reservations.
+func (s *Store) ListPending() ([]*backend.Allocation, error) {
+	resp, err := s.kv.Get(context.TODO(), pendingPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*backend.Allocation, 0, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		a, err := parseAllocation(string(item.Key), string(item.Value))
+		if err != nil {
+			continue
+		}
+		a.ID = strings.TrimPrefix(string(item.Key), pendingPrefix)
+		ret = append(ret, a)
+	}
+	return ret, nil
+}
+
+// Commit writes the allocation of a pending reservation, along with the
+// index of its subnet and the start of its history. Committing twice is a
+// no-op. The store must be locked, so that the address isn't reserved by
+// another container if the reservation expires meanwhile.
+func (s *Store) Commit(id string) error {
+	resp, err := s.kv.Get(context.TODO(), pendingPrefix+id)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) == 0 {
+		ip, err := s.GetByID(id)
+		if err != nil {
+			return err
+		}
+		if ip == nil {
+			return backend.ErrNotFound(fmt.Sprintf("no pending reservation for container %s, it may have expired", id))
+		}
+		return nil
+	}
+	value := string(resp.Kvs[0].Value)
+	a, err := parseAllocation(string(resp.Kvs[0].Key), value)
+	if err != nil {
+		return err
+	}
+	a.ID = id
+
+	// The keys written again are detached from the lease of the
+	// reservation.
+	ops := append([]clientv3.Op{
+		clientv3.OpDelete(pendingPrefix + id),
+		clientv3.OpDelete(unavailableKey(a.IP, unavailablePending)),
+		clientv3.OpPut(ipsPrefix+id, value),
+		clientv3.OpPut(byIPPrefix+a.IP.String(), id),
+		s.reserveHistoryOp(a),
+	}, lookupOps(a, false)...)
+	if err := s.commitIndexed(a.IP, true, ops...); err != nil {
+		return err
+	}
+	// Nothing is attached to the lease anymore, it would expire anyway.
+	if lease := resp.Kvs[0].Lease; lease != 0 {
+		s.lease.Revoke(context.TODO(), clientv3.LeaseID(lease))
+	}
+	return nil
+}
+
+// releasePending deletes the reservation of id, if any, with its keys.
+func (s *Store) releasePending(id string) error {
+	resp, err := s.kv.Get(context.TODO(), pendingPrefix+id)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil
+	}
+	ops := []clientv3.Op{clientv3.OpDelete(pendingPrefix + id)}
+	if a, err := parseAllocation(string(resp.Kvs[0].Key), string(resp.Kvs[0].Value)); err == nil {
+		a.ID = id
+		ops = append(ops,
+			clientv3.OpDelete(byOwnerKey(a)),
+			clientv3.OpDelete(unavailableKey(a.IP, unavailablePending)))
+	}
+	_, err = s.kv.Txn(context.TODO()).Then(ops...).Commit()
+	return err
+}
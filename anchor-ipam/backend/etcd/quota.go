@@ -0,0 +1,64 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+// The quotas are kept in quota/<owner>, with the most addresses the pool of
+// the owner, a tenant or a namespace bound to no tenant, may have allocated
+// or reserved at once.
+const quotaPrefix = "quota/"
+
+func (s *Store) GetQuota(owner string) (int, error) {
+	resp, err := s.kv.Get(context.TODO(), quotaPrefix+owner)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, backend.ErrNotFound(fmt.Sprintf("%s has no quota", owner))
+	}
+	return strconv.Atoi(string(resp.Kvs[0].Value))
+}
+
+// ListQuotas skips the keys under quotaPrefix that aren't quotas.
+func (s *Store) ListQuotas() (map[string]int, error) {
+	values, err := s.listValues(quotaPrefix)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]int, len(values))
+	for owner, value := range values {
+		if max, err := strconv.Atoi(value); err == nil {
+			ret[owner] = max
+		}
+	}
+	return ret, nil
+}
+
+func (s *Store) SetQuota(owner string, max int) error {
+	_, err := s.kv.Put(context.TODO(), quotaPrefix+owner, strconv.Itoa(max))
+	return err
+}
+
+func (s *Store) DeleteQuota(owner string) error {
+	_, err := s.kv.Delete(context.TODO(), quotaPrefix+owner)
+	return err
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+const (
+	// The tenants are kept in tenant/<name>, with their
+	// description. Their pool is in userPrefix, like those of the
+	// namespaces bound to no tenant.
+	tenantPrefix = "tenant/"
+	// The tenant of a namespace is kept in tenant-ns/<namespace>.
+	tenantBindingPrefix = "tenant-ns/"
+)
+
+func (s *Store) listValues(prefix string) (map[string]string, error) {
+	resp, err := s.kv.Get(context.TODO(), prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]string, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		ret[strings.TrimPrefix(string(item.Key), prefix)] = string(item.Value)
+	}
+	return ret, nil
+}
+
+func (s *Store) ListTenants() (map[string]string, error) {
+	return s.listValues(tenantPrefix)
+}
+
+func (s *Store) SetTenant(name string, description string) error {
+	_, err := s.kv.Put(context.TODO(), tenantPrefix+name, description)
+	return err
+}
+
+func (s *Store) DeleteTenant(name string) error {
+	_, err := s.kv.Delete(context.TODO(), tenantPrefix+name)
+	return err
+}
+
+func (s *Store) ListTenantBindings() (map[string]string, error) {
+	return s.listValues(tenantBindingPrefix)
+}
+
+func (s *Store) BindNamespace(namespace string, tenant string) error {
+	_, err := s.kv.Put(context.TODO(), tenantBindingPrefix+namespace, tenant)
+	return err
+}
+
+func (s *Store) UnbindNamespace(namespace string) error {
+	_, err := s.kv.Delete(context.TODO(), tenantBindingPrefix+namespace)
+	return err
+}
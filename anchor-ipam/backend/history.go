@@ -0,0 +1,66 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"time"
+)
+
+// DefaultHistoryRetention is how long the history of a released address is
+// kept, unless configured otherwise.
+const DefaultHistoryRetention = 30 * 24 * time.Hour
+
+// HistoryEntry records that a container held an address from Start to End.
+// End is zero while the address is held. Start is zero for addresses
+// allocated before the history was kept.
+type HistoryEntry struct {
+	ID        string
+	IP        net.IP
+	Pod       string
+	Namespace string
+	App       string
+	Service   string
+	// Node is the node the address was allocated on, when known.
+	Node  string
+	Start time.Time
+	End   time.Time
+}
+
+// HeldAt tells whether the container held the address at t.
+func (e *HistoryEntry) HeldAt(t time.Time) bool {
+	return !e.Start.After(t) && (e.End.IsZero() || e.End.After(t))
+}
+
+// Expired tells whether the address was released before t.
+func (e *HistoryEntry) Expired(t time.Time) bool {
+	return !e.End.IsZero() && e.End.Before(t)
+}
+
+// SortHistory sorts entries by address, then by start.
+func SortHistory(entries []*HistoryEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if c := compareIP(entries[i].IP, entries[j].IP); c != 0 {
+			return c < 0
+		}
+		return entries[i].Start.Before(entries[j].Start)
+	})
+}
+
+func compareIP(a, b net.IP) int {
+	return bytes.Compare(a.To16(), b.To16())
+}
@@ -0,0 +1,65 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("History", func() {
+	t0 := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	It("tells who held an address at a time", func() {
+		released := &HistoryEntry{Start: t0, End: t0.Add(time.Hour)}
+		Expect(released.HeldAt(t0.Add(-time.Second))).To(BeFalse())
+		Expect(released.HeldAt(t0)).To(BeTrue())
+		Expect(released.HeldAt(t0.Add(30 * time.Minute))).To(BeTrue())
+		Expect(released.HeldAt(t0.Add(time.Hour))).To(BeFalse())
+
+		held := &HistoryEntry{Start: t0}
+		Expect(held.HeldAt(t0.Add(24 * time.Hour))).To(BeTrue())
+
+		// Allocated before the history was kept.
+		old := &HistoryEntry{End: t0}
+		Expect(old.HeldAt(t0.Add(-24 * time.Hour))).To(BeTrue())
+	})
+
+	It("only expires released addresses", func() {
+		released := &HistoryEntry{Start: t0, End: t0.Add(time.Hour)}
+		Expect(released.Expired(t0.Add(2 * time.Hour))).To(BeTrue())
+		Expect(released.Expired(t0)).To(BeFalse())
+
+		held := &HistoryEntry{Start: t0}
+		Expect(held.Expired(t0.Add(24 * time.Hour))).To(BeFalse())
+	})
+
+	It("sorts by address then start", func() {
+		entries := []*HistoryEntry{
+			{ID: "c", IP: net.ParseIP("10.0.0.10"), Start: t0},
+			{ID: "b", IP: net.ParseIP("10.0.0.9"), Start: t0.Add(time.Hour)},
+			{ID: "a", IP: net.ParseIP("10.0.0.9").To4(), Start: t0},
+		}
+		SortHistory(entries)
+		ids := []string{}
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+		}
+		Expect(ids).To(Equal([]string{"a", "b", "c"}))
+	})
+})
@@ -14,18 +14,190 @@
 
 package backend
 
-import "net"
+import (
+	"net"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when what is looked up doesn't exist.
+type ErrNotFound string
+
+func (e ErrNotFound) Error() string {
+	return string(e)
+}
+
+// Allocation is an address reserved for a container.
+type Allocation struct {
+	ID        string
+	IP        net.IP
+	Pod       string
+	Namespace string
+	App       string
+	Service   string
+	// Tenant owns the pool the address was allocated from, empty when it
+	// is the namespace.
+	Tenant string
+}
+
+// Owner returns the tenant owning the pool the address was allocated from.
+func (a *Allocation) Owner() string {
+	if a.Tenant != "" {
+		return a.Tenant
+	}
+	return a.Namespace
+}
+
+// Gateway is the gateway registered for a subnet.
+type Gateway struct {
+	Subnet  *net.IPNet
+	Gateway net.IP
+}
+
+// UnknownService is the service recorded for the pods without controller.
+// They aren't one workload, so no hold matches it.
+const UnknownService = "unknown"
+
+// Hold earmarks an address for a workload, so that it is only allocated to
+// its pods, such as before the workload is created.
+type Hold struct {
+	IP        net.IP
+	Namespace string
+	// Service is the workload, as recorded in the allocations.
+	Service string
+	// Expires is when the hold is released, zero if never.
+	Expires time.Time
+}
+
+// Unavailable are the addresses of a subnet that can't be allocated besides
+// the allocations. The held ones can still be allocated to their workload.
+type Unavailable struct {
+	Pending  []net.IP
+	External []net.IP
+	Holds    []*Hold
+}
+
+// PoolTransfer moves addresses from the pool of a tenant to the pool of
+// another.
+type PoolTransfer struct {
+	From string
+	To   string
+	// FromPool and ToPool are the pools after the transfer, in the format
+	// of allocator.LoadRangeSet. An empty FromPool deletes the pool.
+	FromPool string
+	ToPool   string
+	// Allocations are recorded in the pool of To after the transfer.
+	Allocations []*Allocation
+	// FromExclusions and ToExclusions are the exclusions of the pools
+	// after the transfer, which follow the addresses they exclude. An
+	// empty one deletes them.
+	FromExclusions string
+	ToExclusions   string
+	// Holds are the holds of the addresses, rebound to To.
+	Holds []*Hold
+}
+
+// Exclusions are the addresses that are never allocated, in the format of
+// the pools: by subnet for every namespace, and by namespace for its pool.
+type Exclusions struct {
+	Subnets map[string]string
+	Pools   map[string]string
+}
 
 type Store interface {
 	Lock() error
 	Unlock() error
 	Close() error
-	Reserve(id string, ip net.IP, podName string, podNamespace string, app string, service string) (bool, error)
+	// Reserve writes a pending reservation of ip for id, which expires
+	// unless committed. tenant owns the pool of ip.
+	Reserve(id string, ip net.IP, podName string, podNamespace string, app string, service string, tenant string) (bool, error)
+	// Commit makes the pending reservation of id permanent, once the ADD
+	// completed.
+	Commit(id string) error
+	// ListPending returns the reservations not committed yet.
+	ListPending() ([]*Allocation, error)
+	// Release deletes the allocation or pending reservation of id.
 	Release(id string) error
+	// GetByID returns the IP reserved for id, nil if there is none.
+	GetByID(id string) (net.IP, error)
 	ReleaseByIP(ip net.IP) error
+	// GetAllocatedIPs returns the pool of a tenant, or of a namespace
+	// bound to no tenant.
 	GetAllocatedIPs(namespace string) (string, error)
 	GetUsedByPod(pod string, namespace string) ([]net.IP, error)
+	// GetUsedIPbyNamespace returns the addresses allocated or reserved
+	// from the pool of a tenant, see Allocation.Owner.
 	GetUsedIPbyNamespace(namespace string) ([]net.IP, error)
 	GetUsedBySvc(pod string, namespace string) ([]net.IP, error)
 	GetGatewayForIP(ip net.IP) (*net.IPNet, *net.IP, error)
+	// ListAllocations returns every reserved address.
+	ListAllocations() ([]*Allocation, error)
+	// ListPools returns the pool of every namespace, as the ranges it was
+	// configured with.
+	ListPools() (map[string]string, error)
+	// ListGateways returns every registered subnet with its gateway.
+	ListGateways() ([]*Gateway, error)
+	// SetPool replaces the pool of a namespace by ranges, in the format of
+	// allocator.LoadRangeSet.
+	SetPool(namespace string, ranges string) error
+	DeletePool(namespace string) error
+	// ListTenants returns the description of every tenant by name.
+	ListTenants() (map[string]string, error)
+	SetTenant(name string, description string) error
+	DeleteTenant(name string) error
+	// ListTenantBindings returns the tenant of every bound namespace.
+	ListTenantBindings() (map[string]string, error)
+	// BindNamespace binds a namespace to a tenant, replacing any previous
+	// binding.
+	BindNamespace(namespace string, tenant string) error
+	UnbindNamespace(namespace string) error
+	// GetQuota returns the most addresses the pool of a tenant may have
+	// allocated or reserved at once, or ErrNotFound if it has no limit.
+	GetQuota(owner string) (int, error)
+	// ListQuotas returns the quota of every tenant that has one.
+	ListQuotas() (map[string]int, error)
+	SetQuota(owner string, max int) error
+	DeleteQuota(owner string) error
+	// TransferPool applies a transfer atomically, the store must be
+	// locked.
+	TransferPool(t *PoolTransfer) error
+	// SetGateway registers the gateway of a subnet, replacing any previous
+	// one.
+	SetGateway(subnet *net.IPNet, gateway net.IP) error
+	DeleteGateway(subnet *net.IPNet) error
+	// MarkExternal records that something anchor doesn't manage uses ip,
+	// so that it isn't allocated. note tells who found out.
+	MarkExternal(ip net.IP, note string) error
+	ClearExternal(ip net.IP) error
+	// ListExternal returns the notes of the external addresses by IP.
+	ListExternal() (map[string]string, error)
+	ListExclusions() (*Exclusions, error)
+	// SetSubnetExclusions replaces the exclusions of a subnet by ranges, in
+	// the format of allocator.LoadRangeSet.
+	SetSubnetExclusions(subnet *net.IPNet, ranges string) error
+	DeleteSubnetExclusions(subnet *net.IPNet) error
+	// SetPoolExclusions replaces the exclusions of the pool of a namespace.
+	SetPoolExclusions(namespace string, ranges string) error
+	DeletePoolExclusions(namespace string) error
+	// GetIndex returns the allocation index of a registered subnet, which
+	// Reserve and Release keep up to date.
+	GetIndex(subnet *net.IPNet) (*IPBitmap, error)
+	// GetUnavailable returns what can't be allocated in a registered
+	// subnet besides its index, the store must be locked.
+	GetUnavailable(subnet *net.IPNet) (*Unavailable, error)
+	// RebuildIndex rebuilds the index of every subnet from the allocations.
+	RebuildIndex() error
+	// SetHold holds an address for a workload, replacing any previous
+	// hold of the address.
+	SetHold(hold *Hold) error
+	DeleteHold(ip net.IP) error
+	// ListHolds returns the holds not expired.
+	ListHolds() ([]*Hold, error)
+	// GetHistory returns the containers that held ip, by start. Reserve
+	// and Release record them.
+	GetHistory(ip net.IP) ([]*HistoryEntry, error)
+	// ListHistory returns the history of every address.
+	ListHistory() ([]*HistoryEntry, error)
+	// PruneHistory deletes the entries of the addresses released before t,
+	// and returns how many it deleted.
+	PruneHistory(before time.Time) (int, error)
 }
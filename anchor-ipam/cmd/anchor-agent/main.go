@@ -0,0 +1,109 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// anchor-agent runs on every node and serves the anchor-ipam plugin of the
+// node over a unix socket, with long-lived etcd and Kubernetes clients.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/daocloud/anchor/anchor-ipam/agent"
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+	"github.com/daocloud/anchor/anchor-ipam/k8s"
+	"github.com/daocloud/anchor/anchor-ipam/metrics"
+	"github.com/daocloud/anchor/anchor-ipam/plugin"
+)
+
+func main() {
+	conf := flag.String("conf", "/etc/cni/net.d/10-anchor.conf", "CNI network config with the anchor-ipam section")
+	socket := flag.String("socket", "", "unix socket to listen on, defaults to agent_socket of the config or "+agent.DefaultSocket)
+	node := flag.String("node", os.Getenv("NODE_NAME"), "name of this node, to only cache its pods")
+	metricsAddr := flag.String("metrics-addr", ":9402", "address to serve the Prometheus metrics on, empty to disable them")
+	poolMetrics := flag.Bool("pool-metrics", false, "also export the utilization of the pools, enable it on one agent only as it is the same for every node")
+	flag.Parse()
+
+	data, err := ioutil.ReadFile(*conf)
+	if err != nil {
+		log.Fatalf("failed to read %s: %v", *conf, err)
+	}
+	ipamConf, _, err := allocator.LoadIPAMConfig(data, "")
+	if err != nil {
+		log.Fatalf("failed to load %s: %v", *conf, err)
+	}
+	if *node == "" {
+		*node = ipamConf.Kubernetes.NodeName
+	}
+	if *node == "" {
+		log.Fatal("the node name is neither given by --node nor in the config")
+	}
+	if *socket == "" {
+		*socket = ipamConf.AgentSocket
+	}
+	if *socket == "" {
+		*socket = agent.DefaultSocket
+	}
+
+	etcdStore, err := plugin.NewStore(ipamConf)
+	if err != nil {
+		log.Fatalf("failed to connect to etcd: %v", err)
+	}
+	defer etcdStore.Close()
+	etcdStore.Node = *node
+	store := metrics.InstrumentStore(etcdStore)
+
+	k8sClient, err := k8s.NewK8sClient(ipamConf.Kubernetes, ipamConf.Policy)
+	if err != nil {
+		log.Fatalf("failed to create kubernetes client: %v", err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := k8sClient.StartCache(*node, stopCh); err != nil {
+		log.Fatal(err)
+	}
+
+	if *metricsAddr != "" {
+		var pools backend.Store
+		if *poolMetrics {
+			pools = store
+		}
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler(metrics.NewRegistry(pools)))
+			log.Fatal(http.ListenAndServe(*metricsAddr, mux))
+		}()
+	}
+
+	// Networks keyed under another prefix share the socket, and get a
+	// store of their own.
+	openStore := func(conf *allocator.IPAMConfig) (backend.Store, error) {
+		etcdStore, err := plugin.NewStore(conf)
+		if err != nil {
+			return nil, err
+		}
+		etcdStore.Node = *node
+		return metrics.InstrumentStore(etcdStore), nil
+	}
+
+	s := agent.NewServer(ipamConf.EtcdPrefix(), store, openStore, k8sClient)
+	log.Printf("anchor agent of node %s listening on %s", *node, *socket)
+	if err := s.ListenAndServe(*socket, s.Handler()); err != nil {
+		log.Fatal(err)
+	}
+}
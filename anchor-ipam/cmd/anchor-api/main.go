@@ -0,0 +1,79 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// anchor-api serves the static IP API of the governor from etcd, as a
+// drop-in replacement of its Python backend.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/daocloud/anchor/anchor-ipam/api"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+	"github.com/daocloud/anchor/anchor-ipam/plugin"
+)
+
+func main() {
+	listen := flag.String("listen", ":8000", "address to serve the API on")
+	conf := flag.String("conf", "", "CNI network config to take the etcd settings from")
+	endpoints := flag.String("etcd-endpoints", os.Getenv("ETCD_ENDPOINTS"), "comma separated etcd endpoints")
+	certFile := flag.String("etcd-cert-file", os.Getenv("ETCD_CERT"), "etcd client certificate")
+	keyFile := flag.String("etcd-key-file", os.Getenv("ETCD_KEY"), "etcd client key")
+	caFile := flag.String("etcd-ca-cert-file", os.Getenv("ETCD_CA"), "etcd CA certificate")
+	prefix := flag.String("etcd-prefix", os.Getenv("ETCD_PREFIX"), "prefix of the etcd keys of the network, overrides that of -conf, "+allocator.DefaultEtcdPrefix+" without -conf")
+	dceURL := flag.String("dce-url", os.Getenv("DCE_URL"), "DCE to authenticate the requests with, such as https://10.0.0.2:443, empty to let everyone in as an administrator")
+	flag.Parse()
+
+	ipamConf := &allocator.IPAMConfig{
+		Endpoints:     *endpoints,
+		CertFile:      *certFile,
+		KeyFile:       *keyFile,
+		TrustedCAFile: *caFile,
+	}
+	if *conf != "" {
+		data, err := ioutil.ReadFile(*conf)
+		if err != nil {
+			log.Fatalf("failed to read %s: %v", *conf, err)
+		}
+		if ipamConf, _, err = allocator.LoadIPAMConfig(data, ""); err != nil {
+			log.Fatalf("failed to load %s: %v", *conf, err)
+		}
+	}
+	if ipamConf.Endpoints == "" {
+		log.Fatal("no etcd endpoints, set --etcd-endpoints or --conf")
+	}
+	if *prefix != "" {
+		ipamConf.EtcdKeyPrefix = *prefix
+	}
+
+	store, err := plugin.NewStore(ipamConf)
+	if err != nil {
+		log.Fatalf("failed to connect to etcd: %v", err)
+	}
+	defer store.Close()
+
+	var auth api.Authenticator = api.NoAuth{}
+	if *dceURL != "" {
+		auth = api.NewDCEAuth(*dceURL)
+	} else {
+		log.Print("no --dce-url, every request is let in as an administrator")
+	}
+
+	log.Printf("anchor API listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, api.NewServer(store, auth).Handler()))
+}
@@ -0,0 +1,150 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+)
+
+// Scopes of exclusions.
+const (
+	scopeSubnet = "subnet"
+	scopePool   = "pool"
+)
+
+type exclusion struct {
+	Scope string `json:"scope"`
+	// The subnet, or the namespace of the pool.
+	Name   string `json:"name"`
+	Ranges string `json:"ranges"`
+}
+
+func (e *exclusion) row() []string {
+	return []string{e.Scope, e.Name, e.Ranges}
+}
+
+var exclusionHeader = []string{"SCOPE", "NAME", "RANGES"}
+
+// exclusionFlags parses the flags naming what exclusions a command is about,
+// a subnet or the pool of a namespace.
+func exclusionFlags(name string, args []string, nargs int) (*exclusion, []string, error) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	subnet := fs.String("subnet", "", "the exclusions of this subnet, for every namespace")
+	namespace := fs.String("namespace", "", "the exclusions of the pool of this namespace")
+	args, err := parseFlags(fs, args, nargs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case *subnet != "" && *namespace != "":
+		return nil, nil, errors.New("-subnet and -namespace can't be both set")
+	case *subnet != "":
+		_, n, err := net.ParseCIDR(*subnet)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &exclusion{Scope: scopeSubnet, Name: n.String()}, args, nil
+	case *namespace != "":
+		return &exclusion{Scope: scopePool, Name: *namespace}, args, nil
+	default:
+		return nil, nil, errors.New("one of -subnet and -namespace is required")
+	}
+}
+
+func loadExclusions(store backend.Store) (*allocator.Exclusions, error) {
+	stored, err := store.ListExclusions()
+	if err != nil {
+		return nil, err
+	}
+	return allocator.LoadExclusions(stored)
+}
+
+func exclusionList(store backend.Store, args []string) (*output, error) {
+	if _, err := parseFlags(flag.NewFlagSet("exclusion list", flag.ExitOnError), args, 0); err != nil {
+		return nil, err
+	}
+	stored, err := store.ListExclusions()
+	if err != nil {
+		return nil, err
+	}
+
+	value := []*exclusion{}
+	for subnet, ranges := range stored.Subnets {
+		value = append(value, &exclusion{scopeSubnet, subnet, ranges})
+	}
+	for ns, ranges := range stored.Pools {
+		value = append(value, &exclusion{scopePool, ns, ranges})
+	}
+	sort.Slice(value, func(i, j int) bool {
+		if value[i].Scope != value[j].Scope {
+			return value[i].Scope > value[j].Scope
+		}
+		return value[i].Name < value[j].Name
+	})
+
+	out := &output{header: exclusionHeader, value: value}
+	for _, e := range value {
+		out.rows = append(out.rows, e.row())
+	}
+	return out, nil
+}
+
+// exclusionSet replaces the exclusions of a subnet or a pool. Addresses
+// already allocated stay so until released.
+func exclusionSet(store backend.Store, args []string) (*output, error) {
+	e, args, err := exclusionFlags("exclusion set", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	e.Ranges = trimRanges(args[0])
+	if _, err := allocator.LoadRangeSet(e.Ranges); err != nil {
+		return nil, fmt.Errorf("invalid ranges %q: %v", e.Ranges, err)
+	}
+
+	err = locked(store, func() error {
+		if e.Scope == scopePool {
+			return store.SetPoolExclusions(e.Name, e.Ranges)
+		}
+		_, subnet, _ := net.ParseCIDR(e.Name)
+		return store.SetSubnetExclusions(subnet, e.Ranges)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &output{header: exclusionHeader, rows: [][]string{e.row()}, value: e}, nil
+}
+
+func exclusionDelete(store backend.Store, args []string) (*output, error) {
+	e, _, err := exclusionFlags("exclusion delete", args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, locked(store, func() error {
+		if e.Scope == scopePool {
+			return store.DeletePoolExclusions(e.Name)
+		}
+		_, subnet, _ := net.ParseCIDR(e.Name)
+		return store.DeleteSubnetExclusions(subnet)
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+type externalAddress struct {
+	IP   string `json:"ip"`
+	Note string `json:"note"`
+}
+
+// externalList lists the addresses octopus found in use by other hosts,
+// which anchor-ipam doesn't allocate anymore.
+func externalList(store backend.Store, args []string) (*output, error) {
+	if _, err := parseFlags(flag.NewFlagSet("external list", flag.ExitOnError), args, 0); err != nil {
+		return nil, err
+	}
+	external, err := store.ListExternal()
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(external))
+	for s := range external {
+		if ip := net.ParseIP(s); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	sort.Slice(ips, func(i, j int) bool {
+		return compareIP(ips[i], ips[j]) < 0
+	})
+
+	out := &output{header: []string{"IP", "NOTE"}}
+	value := []*externalAddress{}
+	for _, ip := range ips {
+		note := external[ip.String()]
+		out.rows = append(out.rows, []string{ip.String(), note})
+		value = append(value, &externalAddress{ip.String(), note})
+	}
+	out.value = value
+	return out, nil
+}
+
+// externalClear makes an external address allocatable again, once the host
+// using it is gone.
+func externalClear(store backend.Store, args []string) (*output, error) {
+	args, err := parseFlags(flag.NewFlagSet("external clear", flag.ExitOnError), args, 1)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := parseIP(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, locked(store, func() error {
+		external, err := store.ListExternal()
+		if err != nil {
+			return err
+		}
+		if _, ok := external[ip.String()]; !ok {
+			return fmt.Errorf("%s is not marked external", ip)
+		}
+		return store.ClearExternal(ip)
+	})
+}
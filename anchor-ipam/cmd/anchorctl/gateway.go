@@ -0,0 +1,134 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+type gateway struct {
+	Subnet  string `json:"subnet"`
+	Gateway string `json:"gateway"`
+}
+
+func gatewayList(store backend.Store, args []string) (*output, error) {
+	if _, err := parseFlags(flag.NewFlagSet("gateway list", flag.ExitOnError), args, 0); err != nil {
+		return nil, err
+	}
+	gateways, err := store.ListGateways()
+	if err != nil {
+		return nil, err
+	}
+
+	out := &output{header: []string{"SUBNET", "GATEWAY"}}
+	value := []*gateway{}
+	for _, gw := range gateways {
+		out.rows = append(out.rows, []string{gw.Subnet.String(), gw.Gateway.String()})
+		value = append(value, &gateway{gw.Subnet.String(), gw.Gateway.String()})
+	}
+	out.value = value
+	return out, nil
+}
+
+func gatewayAdd(store backend.Store, args []string) (*output, error) {
+	args, err := parseFlags(flag.NewFlagSet("gateway add", flag.ExitOnError), args, 2)
+	if err != nil {
+		return nil, err
+	}
+	_, subnet, err := net.ParseCIDR(args[0])
+	if err != nil {
+		return nil, err
+	}
+	gw := net.ParseIP(args[1])
+	if gw == nil {
+		return nil, fmt.Errorf("invalid gateway %q", args[1])
+	}
+	if !subnet.Contains(gw) {
+		return nil, fmt.Errorf("gateway %s is not in subnet %s", gw, subnet)
+	}
+
+	err = locked(store, func() error {
+		gateways, err := store.ListGateways()
+		if err != nil {
+			return err
+		}
+		for _, other := range gateways {
+			if other.Subnet.String() == subnet.String() {
+				return fmt.Errorf("subnet %s already has gateway %s", subnet, other.Gateway)
+			}
+			if other.Subnet.Contains(subnet.IP) || subnet.Contains(other.Subnet.IP) {
+				return fmt.Errorf("subnet %s overlaps subnet %s", subnet, other.Subnet)
+			}
+		}
+		return store.SetGateway(subnet, gw)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &output{
+		header: []string{"SUBNET", "GATEWAY"},
+		rows:   [][]string{{subnet.String(), gw.String()}},
+		value:  &gateway{subnet.String(), gw.String()},
+	}, nil
+}
+
+func gatewayRemove(store backend.Store, args []string) (*output, error) {
+	fs := flag.NewFlagSet("gateway remove", flag.ExitOnError)
+	force := fs.Bool("force", false, "remove the gateway even if addresses of the subnet are allocated")
+	args, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	_, subnet, err := net.ParseCIDR(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, locked(store, func() error {
+		gateways, err := store.ListGateways()
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, gw := range gateways {
+			if gw.Subnet.String() == subnet.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("subnet %s has no gateway", subnet)
+		}
+
+		allocs, err := store.ListAllocations()
+		if err != nil {
+			return err
+		}
+		used := 0
+		for _, a := range allocs {
+			if subnet.Contains(a.IP) {
+				used++
+			}
+		}
+		if used > 0 && !*force {
+			return fmt.Errorf("subnet %s has %d allocated addresses, use -force to remove its gateway anyway", subnet, used)
+		}
+		return store.DeleteGateway(subnet)
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+var historyHeader = []string{"IP", "NAMESPACE", "POD", "APP", "SERVICE", "NODE", "CONTAINER", "START", "END"}
+
+type historyEntry struct {
+	IP          string `json:"ip"`
+	Namespace   string `json:"namespace"`
+	Pod         string `json:"pod"`
+	App         string `json:"app"`
+	Service     string `json:"service"`
+	Node        string `json:"node,omitempty"`
+	ContainerID string `json:"container_id"`
+	Start       string `json:"start,omitempty"`
+	End         string `json:"end,omitempty"`
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+func historyOutput(entries []*backend.HistoryEntry) *output {
+	out := &output{header: historyHeader}
+	value := []*historyEntry{}
+	for _, e := range entries {
+		h := &historyEntry{e.IP.String(), e.Namespace, e.Pod, e.App, e.Service, e.Node, e.ID, formatTime(e.Start), formatTime(e.End)}
+		value = append(value, h)
+		// Unknown starts predate the history, entries not ended are held.
+		start, end := h.Start, h.End
+		if start == "" {
+			start = "-"
+		}
+		if end == "" {
+			end = "-"
+		}
+		out.rows = append(out.rows, []string{h.IP, h.Namespace, h.Pod, h.App, h.Service, h.Node, h.ContainerID, start, end})
+	}
+	out.value = value
+	return out
+}
+
+// historyShow lists the containers that held an address, or the one that
+// held it at a time.
+func historyShow(store backend.Store, args []string) (*output, error) {
+	fs := flag.NewFlagSet("history show", flag.ExitOnError)
+	at := fs.String("at", "", "only the container holding the address at this RFC 3339 time")
+	args, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := parseIP(args[0])
+	if err != nil {
+		return nil, err
+	}
+	var t time.Time
+	if *at != "" {
+		if t, err = time.Parse(time.RFC3339, *at); err != nil {
+			return nil, fmt.Errorf("invalid time %q: %v", *at, err)
+		}
+	}
+
+	entries, err := store.GetHistory(ip)
+	if err != nil {
+		return nil, err
+	}
+	if *at != "" {
+		held := []*backend.HistoryEntry{}
+		for _, e := range entries {
+			if e.HeldAt(t) {
+				held = append(held, e)
+			}
+		}
+		entries = held
+	}
+	return historyOutput(entries), nil
+}
+
+// historyList lists the addresses a pod or workload held, with the filters.
+func historyList(store backend.Store, args []string) (*output, error) {
+	fs := flag.NewFlagSet("history list", flag.ExitOnError)
+	namespace := fs.String("namespace", "", "only the addresses of this namespace")
+	pod := fs.String("pod", "", "only the addresses of this pod")
+	app := fs.String("app", "", "only the addresses of this app")
+	service := fs.String("service", "", "only the addresses of this workload, as recorded by anchor-ipam")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+
+	entries, err := store.ListHistory()
+	if err != nil {
+		return nil, err
+	}
+	matched := []*backend.HistoryEntry{}
+	for _, e := range entries {
+		if (*namespace != "" && e.Namespace != *namespace) ||
+			(*pod != "" && e.Pod != *pod) ||
+			(*app != "" && e.App != *app) ||
+			(*service != "" && e.Service != *service) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	return historyOutput(matched), nil
+}
+
+// historyRetention is how long the history is kept, history_retention_hours
+// of -conf when set.
+var historyRetention = backend.DefaultHistoryRetention
+
+// historyPrune deletes the history of the addresses released long ago.
+// Releasing an address already prunes its own history, this is for those
+// that aren't allocated again.
+func historyPrune(store backend.Store, args []string) (*output, error) {
+	fs := flag.NewFlagSet("history prune", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", historyRetention, "delete the entries of the addresses released before this long ago")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+
+	var n int
+	err := locked(store, func() error {
+		var err error
+		n, err = store.PruneHistory(time.Now().Add(-*olderThan))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &output{header: []string{"PRUNED"}, rows: [][]string{{fmt.Sprint(n)}}, value: n}, nil
+}
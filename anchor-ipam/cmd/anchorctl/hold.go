@@ -0,0 +1,159 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+)
+
+// Most addresses a command holds or releases at once.
+const maxHoldIPs = 4096
+
+type hold struct {
+	IP        string `json:"ip"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	Expires   string `json:"expires,omitempty"`
+}
+
+// holdIPs returns the addresses of ranges, in the format of the pools.
+func holdIPs(ranges string) ([]net.IP, error) {
+	rs, err := allocator.LoadRangeSet(ranges)
+	if err != nil {
+		return nil, err
+	}
+	if rs.Size().Cmp(big.NewInt(maxHoldIPs)) > 0 {
+		return nil, fmt.Errorf("%s has more than %d addresses", ranges, maxHoldIPs)
+	}
+	ips := []net.IP{}
+	free := rs.FreeIPs(&allocator.RangeSet{})
+	for ip := free.Next(); ip != nil; ip = free.Next() {
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// holdList lists the addresses held for workloads.
+func holdList(store backend.Store, args []string) (*output, error) {
+	fs := flag.NewFlagSet("hold list", flag.ExitOnError)
+	namespace := fs.String("namespace", "", "only the holds of this namespace")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+	holds, err := store.ListHolds()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(holds, func(i, j int) bool {
+		return compareIP(holds[i].IP, holds[j].IP) < 0
+	})
+
+	out := &output{header: []string{"IP", "NAMESPACE", "SERVICE", "EXPIRES"}}
+	value := []*hold{}
+	for _, h := range holds {
+		if *namespace != "" && h.Namespace != *namespace {
+			continue
+		}
+		expires := formatTime(h.Expires)
+		value = append(value, &hold{h.IP.String(), h.Namespace, h.Service, expires})
+		if expires == "" {
+			expires = "never"
+		}
+		out.rows = append(out.rows, []string{h.IP.String(), h.Namespace, h.Service, expires})
+	}
+	out.value = value
+	return out, nil
+}
+
+// holdAdd holds addresses of the pool of a namespace for a workload, which
+// may not exist yet. An address allocated to another pod is only given to
+// the workload once released.
+func holdAdd(store backend.Store, args []string) (*output, error) {
+	fs := flag.NewFlagSet("hold add", flag.ExitOnError)
+	namespace := fs.String("namespace", "", "the namespace of the workload")
+	service := fs.String("service", "", "the workload, as recorded by anchor-ipam")
+	ttl := fs.Duration("ttl", 0, "release the hold after this long, never if 0")
+	args, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if *namespace == "" || *service == "" {
+		return nil, errors.New("-namespace and -service are required")
+	}
+	if *service == backend.UnknownService {
+		return nil, fmt.Errorf("the pods without controller are recorded as service %s, they can't hold addresses", backend.UnknownService)
+	}
+	ips, err := holdIPs(args[0])
+	if err != nil {
+		return nil, err
+	}
+	var expires time.Time
+	if *ttl > 0 {
+		expires = time.Now().Add(*ttl)
+	}
+
+	return nil, locked(store, func() error {
+		pools, err := store.ListPools()
+		if err != nil {
+			return err
+		}
+		pool, err := allocator.LoadRangeSet(pools[*namespace])
+		if err != nil {
+			return fmt.Errorf("namespace %s has no valid pool: %v", *namespace, err)
+		}
+		for _, ip := range ips {
+			if !pool.Includes(ip) {
+				return fmt.Errorf("%s is not in the pool of namespace %s", ip, *namespace)
+			}
+		}
+		for _, ip := range ips {
+			h := &backend.Hold{IP: ip, Namespace: *namespace, Service: *service, Expires: expires}
+			if err := store.SetHold(h); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// holdRelease releases held addresses, whatever workload they are held for.
+func holdRelease(store backend.Store, args []string) (*output, error) {
+	args, err := parseFlags(flag.NewFlagSet("hold release", flag.ExitOnError), args, 1)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := holdIPs(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, locked(store, func() error {
+		for _, ip := range ips {
+			if err := store.DeleteHold(ip); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
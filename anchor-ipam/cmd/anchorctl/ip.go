@@ -0,0 +1,240 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+)
+
+// States of an address.
+const (
+	stateAllocated = "allocated"
+	// Reserved by an ADD not completed yet.
+	statePending = "pending"
+	// In the pool of a namespace, but not allocated.
+	stateFree = "free"
+	// In no pool.
+	stateUnmanaged = "unmanaged"
+	// Found in use by a host anchor doesn't manage, see externalList.
+	stateExternal = "external"
+	// In the pool of a namespace, but never allocated, see exclusionSet.
+	stateExcluded = "excluded"
+	// Not allocated, and only allocated to the pods of a workload, see
+	// holdAdd.
+	stateHeld = "held"
+)
+
+type address struct {
+	IP          string `json:"ip"`
+	State       string `json:"state"`
+	Namespace   string `json:"namespace,omitempty"`
+	Pod         string `json:"pod,omitempty"`
+	App         string `json:"app,omitempty"`
+	Service     string `json:"service,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+}
+
+var addressHeader = []string{"IP", "STATE", "NAMESPACE", "POD", "APP", "SERVICE", "CONTAINER"}
+
+func (a *address) row() []string {
+	return []string{a.IP, a.State, a.Namespace, a.Pod, a.App, a.Service, a.ContainerID}
+}
+
+func allocated(a *backend.Allocation) *address {
+	return &address{
+		IP:          a.IP.String(),
+		State:       stateAllocated,
+		Namespace:   a.Namespace,
+		Pod:         a.Pod,
+		App:         a.App,
+		Service:     a.Service,
+		ContainerID: a.ID,
+	}
+}
+
+func parseIP(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP %q", s)
+	}
+	return ip, nil
+}
+
+// ipShow shows who holds an address, or the pool it is free in.
+func ipShow(store backend.Store, args []string) (*output, error) {
+	args, err := parseFlags(flag.NewFlagSet("ip show", flag.ExitOnError), args, 1)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := parseIP(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	allocs, err := store.ListAllocations()
+	if err != nil {
+		return nil, err
+	}
+	addrs := []*address{}
+	for _, a := range allocs {
+		if a.IP.Equal(ip) {
+			addrs = append(addrs, allocated(a))
+		}
+	}
+	pending, err := store.ListPending()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range pending {
+		if a.IP.Equal(ip) {
+			addr := allocated(a)
+			addr.State = statePending
+			addrs = append(addrs, addr)
+		}
+	}
+
+	if len(addrs) == 0 {
+		addr := &address{IP: ip.String(), State: stateUnmanaged}
+		pools, err := store.ListPools()
+		if err != nil {
+			return nil, err
+		}
+		gateways, err := store.ListGateways()
+		if err != nil {
+			return nil, err
+		}
+		exclusions, err := loadExclusions(store)
+		if err != nil {
+			return nil, err
+		}
+		for ns, p := range pools {
+			rs, err := allocator.LoadRangeSet(p)
+			if err != nil {
+				continue
+			}
+			if rs.Includes(ip) {
+				addr.State = stateFree
+				addr.Namespace = ns
+			}
+			if addr.Namespace != ns {
+				continue
+			}
+			for _, gw := range gateways {
+				if gw.Subnet.Contains(ip) && exclusions.Excluded(ip, gw.Subnet, ns) {
+					addr.State = stateExcluded
+				}
+			}
+		}
+		external, err := store.ListExternal()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := external[ip.String()]; ok {
+			addr.State = stateExternal
+		}
+		holds, err := store.ListHolds()
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range holds {
+			if h.IP.Equal(ip) && addr.State == stateFree {
+				addr.State = stateHeld
+				addr.Service = h.Service
+			}
+		}
+		addrs = append(addrs, addr)
+	}
+
+	out := &output{header: addressHeader, value: addrs}
+	for _, a := range addrs {
+		out.rows = append(out.rows, a.row())
+	}
+	return out, nil
+}
+
+// ipList lists the allocated addresses, of a pod or workload with the
+// filters.
+func ipList(store backend.Store, args []string) (*output, error) {
+	fs := flag.NewFlagSet("ip list", flag.ExitOnError)
+	namespace := fs.String("namespace", "", "only the addresses of this namespace")
+	pod := fs.String("pod", "", "only the addresses of this pod")
+	app := fs.String("app", "", "only the addresses of this app")
+	service := fs.String("service", "", "only the addresses of this workload, as recorded by anchor-ipam")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+
+	allocs, err := store.ListAllocations()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(allocs, func(i, j int) bool {
+		return compareIP(allocs[i].IP, allocs[j].IP) < 0
+	})
+
+	addrs := []*address{}
+	for _, a := range allocs {
+		if (*namespace != "" && a.Namespace != *namespace) ||
+			(*pod != "" && a.Pod != *pod) ||
+			(*app != "" && a.App != *app) ||
+			(*service != "" && a.Service != *service) {
+			continue
+		}
+		addrs = append(addrs, allocated(a))
+	}
+
+	out := &output{header: addressHeader, value: addrs}
+	for _, a := range addrs {
+		out.rows = append(out.rows, a.row())
+	}
+	return out, nil
+}
+
+// ipRelease releases an address whatever holds it, for pods whose DEL never
+// came.
+func ipRelease(store backend.Store, args []string) (*output, error) {
+	args, err := parseFlags(flag.NewFlagSet("ip release", flag.ExitOnError), args, 1)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := parseIP(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, locked(store, func() error {
+		allocs, err := store.ListAllocations()
+		if err != nil {
+			return err
+		}
+		for _, a := range allocs {
+			if a.IP.Equal(ip) {
+				return store.ReleaseByIP(ip)
+			}
+		}
+		return fmt.Errorf("%s is not allocated", ip)
+	})
+}
+
+func compareIP(a, b net.IP) int {
+	return bytes.Compare(a.To16(), b.To16())
+}
@@ -0,0 +1,237 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// anchorctl manages the tenants, pools, gateways and allocations of anchor in
+// etcd.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+	"github.com/daocloud/anchor/anchor-ipam/plugin"
+)
+
+const usage = `Usage: anchorctl [flags] <command> [args]
+
+Commands:
+  pool list
+  pool create <namespace> <ranges>
+  pool delete [-force] <namespace>
+  pool transfer [-move-allocations] [-force] <from> <to> <ranges>
+  tenant list
+  tenant create [-description text] <tenant>
+  tenant delete <tenant>
+  tenant bind <tenant> <namespace>
+  tenant unbind <namespace>
+  quota list
+  quota set <tenant> <max>
+  quota delete <tenant>
+  gateway list
+  gateway add <subnet> <gateway>
+  gateway remove [-force] <subnet>
+  ip show <ip>
+  ip list [-namespace ns] [-pod pod] [-app app] [-service service]
+  ip release <ip>
+  external list
+  external clear <ip>
+  exclusion list
+  exclusion set (-subnet subnet | -namespace ns) <ranges>
+  exclusion delete (-subnet subnet | -namespace ns)
+  index rebuild
+  hold list [-namespace ns]
+  hold add -namespace ns -service service [-ttl duration] <ranges>
+  hold release <ranges>
+  history show [-at time] <ip>
+  history list [-namespace ns] [-pod pod] [-app app] [-service service]
+  history prune [-older-than duration]
+  usage [-subnet subnet]
+
+Flags:
+`
+
+// command runs a command on the store with its arguments, and returns what to
+// print.
+type command func(store backend.Store, args []string) (*output, error)
+
+var commands = map[string]command{
+	"pool list":        poolList,
+	"pool create":      poolCreate,
+	"pool delete":      poolDelete,
+	"pool transfer":    poolTransfer,
+	"tenant list":      tenantList,
+	"tenant create":    tenantCreate,
+	"tenant delete":    tenantDelete,
+	"tenant bind":      tenantBind,
+	"tenant unbind":    tenantUnbind,
+	"quota list":       quotaList,
+	"quota set":        quotaSet,
+	"quota delete":     quotaDelete,
+	"gateway list":     gatewayList,
+	"gateway add":      gatewayAdd,
+	"gateway remove":   gatewayRemove,
+	"ip show":          ipShow,
+	"ip list":          ipList,
+	"ip release":       ipRelease,
+	"external list":    externalList,
+	"external clear":   externalClear,
+	"exclusion list":   exclusionList,
+	"exclusion set":    exclusionSet,
+	"exclusion delete": exclusionDelete,
+	"index rebuild":    indexRebuild,
+	"hold list":        holdList,
+	"hold add":         holdAdd,
+	"hold release":     holdRelease,
+	"history show":     historyShow,
+	"history list":     historyList,
+	"history prune":    historyPrune,
+	"usage":            usageShow,
+}
+
+func main() {
+	conf := flag.String("conf", "", "CNI network config to take the etcd settings from")
+	endpoints := flag.String("etcd-endpoints", os.Getenv("ETCD_ENDPOINTS"), "comma separated etcd endpoints")
+	certFile := flag.String("etcd-cert-file", os.Getenv("ETCD_CERT"), "etcd client certificate")
+	keyFile := flag.String("etcd-key-file", os.Getenv("ETCD_KEY"), "etcd client key")
+	caFile := flag.String("etcd-ca-cert-file", os.Getenv("ETCD_CA"), "etcd CA certificate")
+	prefix := flag.String("etcd-prefix", os.Getenv("ETCD_PREFIX"), "prefix of the etcd keys of the network, overrides that of -conf, "+allocator.DefaultEtcdPrefix+" without -conf")
+	format := flag.String("o", "table", "output format, table or json")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *format != "table" && *format != "json" {
+		fail(fmt.Errorf("unknown output format %q", *format))
+	}
+
+	args := flag.Args()
+	cmd, args := lookup(args)
+	if cmd == nil {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ipamConf := &allocator.IPAMConfig{
+		Endpoints:     *endpoints,
+		CertFile:      *certFile,
+		KeyFile:       *keyFile,
+		TrustedCAFile: *caFile,
+	}
+	if *conf != "" {
+		data, err := ioutil.ReadFile(*conf)
+		if err != nil {
+			fail(err)
+		}
+		if ipamConf, _, err = allocator.LoadIPAMConfig(data, ""); err != nil {
+			fail(err)
+		}
+	}
+	if ipamConf.Endpoints == "" {
+		fail(fmt.Errorf("no etcd endpoints, set -etcd-endpoints or -conf"))
+	}
+	if *prefix != "" {
+		ipamConf.EtcdKeyPrefix = *prefix
+	}
+	if ipamConf.HistoryRetentionHours > 0 {
+		historyRetention = time.Duration(ipamConf.HistoryRetentionHours) * time.Hour
+	}
+
+	store, err := plugin.NewStore(ipamConf)
+	if err != nil {
+		fail(err)
+	}
+	defer store.Close()
+
+	out, err := cmd(store, args)
+	if err != nil {
+		fail(err)
+	}
+	if out != nil {
+		if err := out.print(*format); err != nil {
+			fail(err)
+		}
+	}
+}
+
+// lookup returns the command named by the first one or two arguments, and
+// the arguments left.
+func lookup(args []string) (command, []string) {
+	for n := 2; n > 0; n-- {
+		if len(args) < n {
+			continue
+		}
+		if cmd, ok := commands[strings.Join(args[:n], " ")]; ok {
+			return cmd, args[n:]
+		}
+	}
+	return nil, nil
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "anchorctl: %v\n", err)
+	os.Exit(1)
+}
+
+// output is what a command prints, as a table or as JSON.
+type output struct {
+	header []string
+	rows   [][]string
+	// value is printed as JSON.
+	value interface{}
+}
+
+func (o *output) print(format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		return enc.Encode(o.value)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(o.header, "\t"))
+	for _, row := range o.rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// parseFlags parses the flags of a command, which needs nargs arguments.
+func parseFlags(fs *flag.FlagSet, args []string, nargs int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != nargs {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", fs.Name(), nargs, fs.NArg())
+	}
+	return fs.Args(), nil
+}
+
+// locked runs f with the store locked, so it doesn't race with allocations.
+func locked(store backend.Store, f func() error) error {
+	if err := store.Lock(); err != nil {
+		return err
+	}
+	defer store.Unlock()
+	return f()
+}
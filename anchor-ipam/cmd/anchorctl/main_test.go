@@ -0,0 +1,48 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("anchorctl", func() {
+	It("looks commands up by their one or two words", func() {
+		cmd, args := lookup([]string{"pool", "create", "team-a", "10.0.1.[2-9]"})
+		Expect(cmd).NotTo(BeNil())
+		Expect(args).To(Equal([]string{"team-a", "10.0.1.[2-9]"}))
+
+		cmd, args = lookup([]string{"usage", "-subnet", "10.0.1.0/24"})
+		Expect(cmd).NotTo(BeNil())
+		Expect(args).To(Equal([]string{"-subnet", "10.0.1.0/24"}))
+
+		cmd, _ = lookup([]string{"pool"})
+		Expect(cmd).To(BeNil())
+	})
+
+	It("expands the ranges of holds", func() {
+		ips, err := holdIPs("10.0.1.[8-9],10.0.1.20")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ips).To(HaveLen(3))
+		Expect(ips[0].Equal(net.ParseIP("10.0.1.8"))).To(BeTrue())
+		Expect(ips[2].Equal(net.ParseIP("10.0.1.20"))).To(BeTrue())
+
+		_, err = holdIPs("10.0.0.0/16")
+		Expect(err).To(HaveOccurred())
+	})
+})
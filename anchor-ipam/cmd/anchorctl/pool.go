@@ -0,0 +1,204 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+)
+
+type pool struct {
+	Namespace string `json:"namespace"`
+	Ranges    string `json:"ranges"`
+}
+
+func poolList(store backend.Store, args []string) (*output, error) {
+	if _, err := parseFlags(flag.NewFlagSet("pool list", flag.ExitOnError), args, 0); err != nil {
+		return nil, err
+	}
+	pools, err := store.ListPools()
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := make([]string, 0, len(pools))
+	for ns := range pools {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	out := &output{header: []string{"NAMESPACE", "RANGES"}}
+	value := []*pool{}
+	for _, ns := range namespaces {
+		out.rows = append(out.rows, []string{ns, pools[ns]})
+		value = append(value, &pool{ns, pools[ns]})
+	}
+	out.value = value
+	return out, nil
+}
+
+func poolCreate(store backend.Store, args []string) (*output, error) {
+	args, err := parseFlags(flag.NewFlagSet("pool create", flag.ExitOnError), args, 2)
+	if err != nil {
+		return nil, err
+	}
+	namespace := args[0]
+
+	ranges := trimRanges(args[1])
+	rs, err := allocator.LoadRangeSet(ranges)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ranges %q: %v", ranges, err)
+	}
+
+	err = locked(store, func() error {
+		pools, err := store.ListPools()
+		if err != nil {
+			return err
+		}
+		if _, ok := pools[namespace]; ok {
+			return fmt.Errorf("namespace %s already has a pool", namespace)
+		}
+		for ns, p := range pools {
+			other, err := allocator.LoadRangeSet(p)
+			if err != nil {
+				continue
+			}
+			if r := rs.FirstOverlap(other); r != nil {
+				return fmt.Errorf("%s is already in the pool of namespace %s", r.String(), ns)
+			}
+		}
+
+		gateways, err := store.ListGateways()
+		if err != nil {
+			return err
+		}
+		for _, r := range *rs {
+			if !hasGateway(gateways, r) {
+				fmt.Fprintf(os.Stderr, "warning: no gateway is registered for %s, it can't be allocated until one is\n", r.String())
+			}
+		}
+
+		return store.SetPool(namespace, ranges)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &output{
+		header: []string{"NAMESPACE", "RANGES"},
+		rows:   [][]string{{namespace, ranges}},
+		value:  &pool{namespace, ranges},
+	}, nil
+}
+
+func poolDelete(store backend.Store, args []string) (*output, error) {
+	fs := flag.NewFlagSet("pool delete", flag.ExitOnError)
+	force := fs.Bool("force", false, "delete the pool even if addresses of the namespace are allocated")
+	args, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	namespace := args[0]
+
+	return nil, locked(store, func() error {
+		if _, err := store.GetAllocatedIPs(namespace); err != nil {
+			return err
+		}
+		used, err := store.GetUsedIPbyNamespace(namespace)
+		if err != nil {
+			return err
+		}
+		if len(used) > 0 && !*force {
+			return fmt.Errorf("namespace %s has %d allocated addresses, use -force to delete its pool anyway", namespace, len(used))
+		}
+		return store.DeletePool(namespace)
+	})
+}
+
+// poolTransfer moves ranges of the pool of a namespace to the pool of
+// another, such as when a team moves to a new namespace.
+func poolTransfer(store backend.Store, args []string) (*output, error) {
+	fs := flag.NewFlagSet("pool transfer", flag.ExitOnError)
+	move := fs.Bool("move-allocations", false, "also record the allocations of the ranges in the new namespace")
+	force := fs.Bool("force", false, "transfer the ranges even if some of their addresses are in use")
+	args, err := parseFlags(fs, args, 3)
+	if err != nil {
+		return nil, err
+	}
+	from, to, ranges := args[0], args[1], trimRanges(args[2])
+
+	var t *backend.PoolTransfer
+	err = locked(store, func() error {
+		pools, err := store.ListPools()
+		if err != nil {
+			return err
+		}
+		allocs, err := store.ListAllocations()
+		if err != nil {
+			return err
+		}
+		pending, err := store.ListPending()
+		if err != nil {
+			return err
+		}
+		exclusions, err := store.ListExclusions()
+		if err != nil {
+			return err
+		}
+		holds, err := store.ListHolds()
+		if err != nil {
+			return err
+		}
+		opts := allocator.TransferOptions{MoveAllocations: *move, Force: *force}
+		if t, err = allocator.PlanTransfer(pools, exclusions, holds, allocs, pending, from, to, ranges, opts); err != nil {
+			return err
+		}
+		return store.TransferPool(t)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := &output{header: []string{"NAMESPACE", "RANGES"}, value: []*pool{{from, t.FromPool}, {to, t.ToPool}}}
+	out.rows = [][]string{{from, t.FromPool}, {to, t.ToPool}}
+	if len(t.Allocations) > 0 {
+		fmt.Fprintf(os.Stderr, "moved %d allocations to namespace %s\n", len(t.Allocations), to)
+	}
+	return out, nil
+}
+
+func hasGateway(gateways []*backend.Gateway, r allocator.Range) bool {
+	for _, gw := range gateways {
+		if gw.Subnet.Contains(r.RangeStart) && gw.Subnet.Contains(r.RangeEnd) {
+			return true
+		}
+	}
+	return false
+}
+
+// trimRanges trims the ranges of a pool, which are stored the way they are
+// written, as the allocator parses them.
+func trimRanges(s string) string {
+	parts := strings.Split(s, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,96 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+type quota struct {
+	// The tenant, or the namespace bound to no tenant, owning the pool.
+	Owner string `json:"owner"`
+	Max   int    `json:"max"`
+	Used  int    `json:"used"`
+}
+
+// quotaList lists the quotas with the addresses allocated or reserved from
+// their pool.
+func quotaList(store backend.Store, args []string) (*output, error) {
+	if _, err := parseFlags(flag.NewFlagSet("quota list", flag.ExitOnError), args, 0); err != nil {
+		return nil, err
+	}
+	quotas, err := store.ListQuotas()
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make([]string, 0, len(quotas))
+	for owner := range quotas {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	out := &output{header: []string{"OWNER", "MAX", "USED"}}
+	value := []*quota{}
+	for _, owner := range owners {
+		used, err := store.GetUsedIPbyNamespace(owner)
+		if err != nil {
+			return nil, err
+		}
+		q := &quota{Owner: owner, Max: quotas[owner], Used: len(used)}
+		value = append(value, q)
+		out.rows = append(out.rows, []string{q.Owner, strconv.Itoa(q.Max), strconv.Itoa(q.Used)})
+	}
+	out.value = value
+	return out, nil
+}
+
+// quotaSet limits the addresses allocated or reserved at once from the pool
+// of a tenant, or of a namespace bound to no tenant. Addresses already
+// allocated past the quota stay so until released.
+func quotaSet(store backend.Store, args []string) (*output, error) {
+	args, err := parseFlags(flag.NewFlagSet("quota set", flag.ExitOnError), args, 2)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTenantName(args[0]); err != nil {
+		return nil, err
+	}
+	owner := args[0]
+	max, err := strconv.Atoi(args[1])
+	if err != nil || max < 0 {
+		return nil, fmt.Errorf("invalid quota %q", args[1])
+	}
+
+	return nil, locked(store, func() error {
+		return store.SetQuota(owner, max)
+	})
+}
+
+func quotaDelete(store backend.Store, args []string) (*output, error) {
+	args, err := parseFlags(flag.NewFlagSet("quota delete", flag.ExitOnError), args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, locked(store, func() error {
+		return store.DeleteQuota(args[0])
+	})
+}
@@ -0,0 +1,192 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+type tenant struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Namespaces  []string `json:"namespaces"`
+	Pool        string   `json:"pool"`
+}
+
+// checkTenantName refuses the names that can't be keys or be recorded in
+// the allocations.
+func checkTenantName(name string) error {
+	if name == "" || strings.ContainsAny(name, ",/") {
+		return fmt.Errorf("invalid tenant name %q", name)
+	}
+	return nil
+}
+
+// tenantList lists the tenants with their namespaces and pool.
+func tenantList(store backend.Store, args []string) (*output, error) {
+	if _, err := parseFlags(flag.NewFlagSet("tenant list", flag.ExitOnError), args, 0); err != nil {
+		return nil, err
+	}
+	tenants, err := store.ListTenants()
+	if err != nil {
+		return nil, err
+	}
+	bindings, err := store.ListTenantBindings()
+	if err != nil {
+		return nil, err
+	}
+	pools, err := store.ListPools()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(tenants))
+	for name := range tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	namespaces := map[string][]string{}
+	for ns, name := range bindings {
+		namespaces[name] = append(namespaces[name], ns)
+	}
+
+	out := &output{header: []string{"TENANT", "NAMESPACES", "POOL", "DESCRIPTION"}}
+	value := []*tenant{}
+	for _, name := range names {
+		t := &tenant{Name: name, Description: tenants[name], Namespaces: namespaces[name], Pool: pools[name]}
+		sort.Strings(t.Namespaces)
+		value = append(value, t)
+		out.rows = append(out.rows, []string{t.Name, strings.Join(t.Namespaces, ","), t.Pool, t.Description})
+	}
+	out.value = value
+	return out, nil
+}
+
+// tenantCreate creates a tenant, or changes its description. Its pool is
+// created with poolCreate, under the name of the tenant, so a namespace
+// with a pool can't give its name to a tenant.
+func tenantCreate(store backend.Store, args []string) (*output, error) {
+	fs := flag.NewFlagSet("tenant create", flag.ExitOnError)
+	description := fs.String("description", "", "what the tenant is")
+	args, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTenantName(args[0]); err != nil {
+		return nil, err
+	}
+	name := args[0]
+
+	return nil, locked(store, func() error {
+		tenants, err := store.ListTenants()
+		if err != nil {
+			return err
+		}
+		if _, ok := tenants[name]; !ok {
+			pools, err := store.ListPools()
+			if err != nil {
+				return err
+			}
+			if _, ok := pools[name]; ok {
+				return fmt.Errorf("namespace %s has a pool, a tenant can't take its name", name)
+			}
+		}
+		return store.SetTenant(name, *description)
+	})
+}
+
+// tenantDelete deletes a tenant without namespaces nor pool.
+func tenantDelete(store backend.Store, args []string) (*output, error) {
+	args, err := parseFlags(flag.NewFlagSet("tenant delete", flag.ExitOnError), args, 1)
+	if err != nil {
+		return nil, err
+	}
+	name := args[0]
+
+	return nil, locked(store, func() error {
+		tenants, err := store.ListTenants()
+		if err != nil {
+			return err
+		}
+		if _, ok := tenants[name]; !ok {
+			return fmt.Errorf("tenant %s doesn't exist", name)
+		}
+		bindings, err := store.ListTenantBindings()
+		if err != nil {
+			return err
+		}
+		for ns, t := range bindings {
+			if t == name {
+				return fmt.Errorf("namespace %s is bound to tenant %s, unbind it first", ns, name)
+			}
+		}
+		pools, err := store.ListPools()
+		if err != nil {
+			return err
+		}
+		if _, ok := pools[name]; ok {
+			return fmt.Errorf("tenant %s has a pool, delete it first", name)
+		}
+		return store.DeleteTenant(name)
+	})
+}
+
+// tenantBind binds a namespace to a tenant, so that its pods are allocated
+// from the pool of the tenant. The addresses already allocated stay in the
+// pool they were allocated from.
+func tenantBind(store backend.Store, args []string) (*output, error) {
+	args, err := parseFlags(flag.NewFlagSet("tenant bind", flag.ExitOnError), args, 2)
+	if err != nil {
+		return nil, err
+	}
+	name, namespace := args[0], args[1]
+
+	return nil, locked(store, func() error {
+		tenants, err := store.ListTenants()
+		if err != nil {
+			return err
+		}
+		if _, ok := tenants[name]; !ok {
+			return fmt.Errorf("tenant %s doesn't exist", name)
+		}
+		return store.BindNamespace(namespace, name)
+	})
+}
+
+// tenantUnbind unbinds a namespace from its tenant, its pods are allocated
+// from the pool of the namespace again.
+func tenantUnbind(store backend.Store, args []string) (*output, error) {
+	args, err := parseFlags(flag.NewFlagSet("tenant unbind", flag.ExitOnError), args, 1)
+	if err != nil {
+		return nil, err
+	}
+	namespace := args[0]
+
+	return nil, locked(store, func() error {
+		bindings, err := store.ListTenantBindings()
+		if err != nil {
+			return err
+		}
+		if _, ok := bindings[namespace]; !ok {
+			return fmt.Errorf("namespace %s is bound to no tenant", namespace)
+		}
+		return store.UnbindNamespace(namespace)
+	})
+}
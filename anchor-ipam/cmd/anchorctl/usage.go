@@ -0,0 +1,84 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"sort"
+	"strconv"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+)
+
+// usageShow shows the utilization of the pool of every namespace in every
+// subnet.
+func usageShow(store backend.Store, args []string) (*output, error) {
+	fs := flag.NewFlagSet("usage", flag.ExitOnError)
+	subnet := fs.String("subnet", "", "only this subnet")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+
+	pools, err := store.ListPools()
+	if err != nil {
+		return nil, err
+	}
+	gateways, err := store.ListGateways()
+	if err != nil {
+		return nil, err
+	}
+	allocs, err := store.ListAllocations()
+	if err != nil {
+		return nil, err
+	}
+
+	exclusions, err := loadExclusions(store)
+	if err != nil {
+		return nil, err
+	}
+
+	usages := []*allocator.PoolUsage{}
+	for _, u := range allocator.PoolUsages(pools, gateways, allocs, exclusions) {
+		if *subnet == "" || u.Subnet == *subnet {
+			usages = append(usages, u)
+		}
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Subnet != usages[j].Subnet {
+			return usages[i].Subnet < usages[j].Subnet
+		}
+		return usages[i].Namespace < usages[j].Namespace
+	})
+
+	out := &output{header: []string{"SUBNET", "NAMESPACE", "TOTAL", "USED", "FREE"}, value: usages}
+	for _, u := range usages {
+		out.rows = append(out.rows, []string{u.Subnet, u.Namespace, count(u.Total), count(u.Used), count(u.Free)})
+	}
+	return out, nil
+}
+
+func count(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// indexRebuild rebuilds the allocation index from the allocations, after
+// they were written by anchor-ipam versions that didn't keep it.
+func indexRebuild(store backend.Store, args []string) (*output, error) {
+	if _, err := parseFlags(flag.NewFlagSet("index rebuild", flag.ExitOnError), args, 0); err != nil {
+		return nil, err
+	}
+	return nil, locked(store, store.RebuildIndex)
+}
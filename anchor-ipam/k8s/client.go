@@ -0,0 +1,78 @@
+// Copyright 2015 Tigera Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Client is a Kubernetes client. Once StartCache is called, pods and
+// namespaces are served from informer caches, and only looked up on the API
+// server when missing from them.
+type Client struct {
+	kubernetes.Interface
+
+	pods       listers.PodLister
+	namespaces listers.NamespaceLister
+}
+
+// StartCache starts the informers of the pods of node and of the namespaces,
+// and waits for them to sync. They stop when stopCh is closed.
+func (c *Client) StartCache(node string, stopCh <-chan struct{}) error {
+	restClient := c.CoreV1().RESTClient()
+
+	podInformer := cache.NewSharedIndexInformer(
+		cache.NewListWatchFromClient(restClient, "pods", v1.NamespaceAll, fields.OneTermEqualSelector("spec.nodeName", node)),
+		&corev1.Pod{}, 10*time.Minute, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	nsInformer := cache.NewSharedIndexInformer(
+		cache.NewListWatchFromClient(restClient, "namespaces", v1.NamespaceAll, fields.Everything()),
+		&corev1.Namespace{}, 10*time.Minute, cache.Indexers{})
+
+	go podInformer.Run(stopCh)
+	go nsInformer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, podInformer.HasSynced, nsInformer.HasSynced) {
+		return fmt.Errorf("failed to sync pod and namespace caches")
+	}
+
+	c.pods = listers.NewPodLister(podInformer.GetIndexer())
+	c.namespaces = listers.NewNamespaceLister(nsInformer.GetIndexer())
+	return nil
+}
+
+func (c *Client) getPod(name, namespace string) (*corev1.Pod, error) {
+	if c.pods != nil {
+		if pod, err := c.pods.Pods(namespace).Get(name); err == nil {
+			return pod, nil
+		}
+	}
+	return c.CoreV1().Pods(namespace).Get(name, v1.GetOptions{})
+}
+
+func (c *Client) getNamespace(name string) (*corev1.Namespace, error) {
+	if c.namespaces != nil {
+		if ns, err := c.namespaces.Get(name); err == nil {
+			return ns, nil
+		}
+	}
+	return c.CoreV1().Namespaces().Get(name, v1.GetOptions{})
+}
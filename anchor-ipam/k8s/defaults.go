@@ -0,0 +1,111 @@
+// Copyright 2015 Tigera Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AnnotationPrefix is the prefix of the annotations configuring the network
+// of a pod, such as cni.daocloud.io/subnet.
+const AnnotationPrefix = "cni.daocloud.io/"
+
+// InheritAnnotations returns the network annotations of a pod, completed by
+// those of its owning workload when withWorkload is set, and then by those of
+// its namespace. The closest object wins, so a pod can override the defaults
+// of its namespace.
+// keys are the annotations the caller reads: the workload and the namespace
+// are only looked up while one of them is missing, and one that can't be
+// read, such as without the RBAC to get namespaces, gives no defaults.
+func InheritAnnotations(client *Client, podName, namespace string, annot map[string]string, keys []string, withWorkload bool) map[string]string {
+	ret := map[string]string{}
+	merge := func(from map[string]string) {
+		for k, v := range from {
+			if _, ok := ret[k]; !ok && strings.HasPrefix(k, AnnotationPrefix) {
+				ret[k] = v
+			}
+		}
+	}
+	missing := func() bool {
+		for _, k := range keys {
+			if _, ok := ret[k]; !ok {
+				return true
+			}
+		}
+		return false
+	}
+	merge(annot)
+
+	if withWorkload && missing() {
+		if workload, err := GetWorkloadAnnotations(client, podName, namespace); err == nil {
+			merge(workload)
+		}
+	}
+
+	if missing() {
+		if ns, err := client.getNamespace(namespace); err == nil {
+			merge(ns.Annotations)
+		}
+	}
+	return ret
+}
+
+// GetWorkloadAnnotations returns the annotations of the workload owning a
+// pod, following ReplicaSets up to their Deployment. A pod without controller
+// has no workload annotations.
+func GetWorkloadAnnotations(client *Client, podName, namespace string) (map[string]string, error) {
+	pod, err := client.getPod(podName, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := v1.GetControllerOf(pod)
+	if ref == nil {
+		return nil, nil
+	}
+
+	apps := client.AppsV1beta2()
+	switch strings.ToLower(ref.Kind) {
+	case "replicaset":
+		rs, err := apps.ReplicaSets(namespace).Get(ref.Name, v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		owner := v1.GetControllerOf(rs)
+		if owner == nil || strings.ToLower(owner.Kind) != "deployment" {
+			return rs.Annotations, nil
+		}
+		deploy, err := apps.Deployments(namespace).Get(owner.Name, v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return deploy.Annotations, nil
+	case "statefulset":
+		sts, err := apps.StatefulSets(namespace).Get(ref.Name, v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return sts.Annotations, nil
+	case "daemonset":
+		ds, err := apps.DaemonSets(namespace).Get(ref.Name, v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return ds.Annotations, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2015 Tigera Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const eventSource = "anchor-ipam"
+
+// RecordPodEvent records a warning event about a pod, shown by
+// `kubectl describe pod`.
+func RecordPodEvent(client *Client, podName, namespace, node, reason, message string) error {
+	pod, err := client.getPod(podName, namespace)
+	if err != nil {
+		return err
+	}
+	return recordEvent(client, namespace, node, corev1.ObjectReference{
+		Kind:            "Pod",
+		APIVersion:      "v1",
+		Namespace:       namespace,
+		Name:            podName,
+		UID:             pod.UID,
+		ResourceVersion: pod.ResourceVersion,
+	}, reason, message)
+}
+
+// RecordNamespaceEvent records a warning event about a namespace, shown by
+// `kubectl describe namespace`. The event lives in the namespace itself so
+// that its users see it too.
+func RecordNamespaceEvent(client *Client, namespace, node, reason, message string) error {
+	ns, err := client.getNamespace(namespace)
+	if err != nil {
+		return err
+	}
+	return recordEvent(client, namespace, node, corev1.ObjectReference{
+		Kind:            "Namespace",
+		APIVersion:      "v1",
+		Name:            namespace,
+		UID:             ns.UID,
+		ResourceVersion: ns.ResourceVersion,
+	}, reason, message)
+}
+
+func recordEvent(client *Client, namespace, node string, ref corev1.ObjectReference, reason, message string) error {
+	now := v1.Now()
+	event := &corev1.Event{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: ref,
+		Reason:         reason,
+		Message:        message,
+		Type:           corev1.EventTypeWarning,
+		Source: corev1.EventSource{
+			Component: eventSource,
+			Host:      node,
+		},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	_, err := client.CoreV1().Events(namespace).Create(event)
+	return err
+}
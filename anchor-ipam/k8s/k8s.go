@@ -15,15 +15,15 @@
 package k8s
 
 import (
-	"strings"
 	"fmt"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-func NewK8sClient(kuber Kubernetes, policy Policy) (*kubernetes.Clientset, error) {
+func NewK8sClient(kuber Kubernetes, policy Policy) (*Client, error) {
 	// Some config can be passed in a kubeconfig file
 	kubeconfig := kuber.Kubeconfig
 	// Config can be overridden by config passed in explicitly in the network config.
@@ -66,11 +66,15 @@ func NewK8sClient(kuber Kubernetes, policy Policy) (*kubernetes.Clientset, error
 	}
 
 	// Create the clientset
-	return kubernetes.NewForConfig(config)
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Interface: clientset}, nil
 }
 
-func GetK8sPodInfo(client *kubernetes.Clientset, podName, podNamespace string) (labels map[string]string, annotations map[string]string, err error) {
-	pod, err := client.CoreV1().Pods(string(podNamespace)).Get(podName, v1.GetOptions{})
+func GetK8sPodInfo(client *Client, podName, podNamespace string) (labels map[string]string, annotations map[string]string, err error) {
+	pod, err := client.getPod(podName, podNamespace)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -79,9 +83,9 @@ func GetK8sPodInfo(client *kubernetes.Clientset, podName, podNamespace string) (
 
 // ResourceControllerName get the name of ResourceController based on given reference.
 // to convert owner/created by references to real objects.
-func ResourceControllerName(client *kubernetes.Clientset, podName, namespace string) (
+func ResourceControllerName(client *Client, podName, namespace string) (
 	string, error) {
-	pod, err := client.CoreV1().Pods(string(namespace)).Get(podName, v1.GetOptions{})
+	pod, err := client.getPod(podName, namespace)
 	if err != nil {
 		return "", err
 	}
@@ -73,4 +73,10 @@ type K8sArgs struct {
 	K8S_POD_NAME               types.UnmarshallableString
 	K8S_POD_NAMESPACE          types.UnmarshallableString
 	K8S_POD_INFRA_CONTAINER_ID types.UnmarshallableString
+	// Set by octopus when it found the address of its previous attempt in
+	// use on the network, to allocate another one.
+	ANCHOR_CONFLICT_IP net.IP
+	// Set by octopus once the pod is up, to commit the reservation of the
+	// container rather than allocate.
+	ANCHOR_COMMIT types.UnmarshallableBool
 }
@@ -15,13 +15,10 @@
 package main
 
 import (
+	"github.com/daocloud/anchor/anchor-ipam/agent"
 	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
-	"github.com/daocloud/anchor/anchor-ipam/backend/etcd"
 	"github.com/daocloud/anchor/anchor-ipam/k8s"
-	"github.com/coreos/etcd/pkg/transport"
-	"net"
-	"strings"
-	"fmt"
+	"github.com/daocloud/anchor/anchor-ipam/plugin"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
@@ -34,148 +31,65 @@ func main() {
 	skel.PluginMain(cmdAdd, cmdGet, cmdDel, version.All, "TODO")
 }
 
-func cmdGet(args *skel.CmdArgs) error {
-	// TODO: implement
-	return fmt.Errorf("not implemented")
-}
-
-// TODO: create a seperate function named etcdClient which return kv and mutex.
-func cmdAdd(args *skel.CmdArgs) error {
-	ipamConf, confVersion, err := allocator.LoadIPAMConfig(args.StdinData, args.Args)
-	if err != nil {
-		return err
-	}
-	result := &current.Result{}
+// The anchor agent of the node does the work when it runs, keeping its etcd
+// and Kubernetes clients across calls. Otherwise the plugin falls back to
+// doing it itself.
 
-	tlsInfo := &transport.TLSInfo{
-		CertFile:      ipamConf.CertFile,
-		KeyFile:       ipamConf.KeyFile,
-		TrustedCAFile: ipamConf.TrustedCAFile,
+func agentRequest(args *skel.CmdArgs) *agent.Request {
+	return &agent.Request{
+		ContainerID: args.ContainerID,
+		Args:        args.Args,
+		StdinData:   args.StdinData,
 	}
-	tlsConfig, _ := tlsInfo.ClientConfig()
+}
 
-	store, err := etcd.New(ipamConf.Name, strings.Split(ipamConf.Endpoints, ","), tlsConfig)
-	defer store.Close()
+func cmdGet(args *skel.CmdArgs) error {
+	ipamConf, _, err := allocator.LoadIPAMConfig(args.StdinData, args.Args)
 	if err != nil {
 		return err
 	}
 
-	// Get annotations of the pod, such as ipAddrs and current user.
-
-	// 1. Get conf for k8s client and create a k8s_client
-	k8sClient, err := k8s.NewK8sClient(ipamConf.Kubernetes, ipamConf.Policy)
-	if err != nil {
+	err = agent.NewClient(ipamConf.AgentSocket).Check(agentRequest(args))
+	if err != agent.ErrUnavailable {
 		return err
 	}
 
-	// 2. Get K8S_POD_NAME and K8S_POD_NAMESPACE.
-	k8sArgs := k8s.K8sArgs{}
-	if err := types.LoadArgs(args.Args, &k8sArgs); err != nil {
+	store, err := plugin.NewStore(ipamConf)
+	if err != nil {
 		return err
 	}
+	defer store.Close()
+	return plugin.Check(store, args.ContainerID)
+}
 
-	// 3. Get annotations from k8s_client via K8S_POD_NAME and K8S_POD_NAMESPACE.
-	label, annot, err := k8s.GetK8sPodInfo(k8sClient, string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE))
+func cmdAdd(args *skel.CmdArgs) error {
+	ipamConf, confVersion, err := allocator.LoadIPAMConfig(args.StdinData, args.Args)
 	if err != nil {
-		return fmt.Errorf("Error while read annotaions for pod" + err.Error())
-	}
-
-	userDefinedSubnet := annot["cni.daocloud.io/subnet"]
-	userDefinedRoutes := annot["cni.daocloud.io/routes"]
-	userDefinedGateway := annot["cni.daocloud.io/gateway"]
-
-	// app := label["io.daocloud.dce.app"]
-	app := label["dce.daocloud.io/app"]
-	if app == "" {
-		app = "unknown"
-	}
-	service, err := k8s.ResourceControllerName(k8sClient, string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE))
-
-	if service == "" {
-		service = "unknown"
+		return err
 	}
 
-	if userDefinedSubnet == "" {
-		return fmt.Errorf("No ip found for pod " + string(k8sArgs.K8S_POD_NAME))
+	result, err := agent.NewClient(ipamConf.AgentSocket).Allocate(agentRequest(args))
+	if err == agent.ErrUnavailable {
+		result, err = addDirect(ipamConf, args)
 	}
-
-	_, subnet, err := net.ParseCIDR(userDefinedSubnet)
 	if err != nil {
 		return err
 	}
+	return types.PrintResult(result, confVersion)
+}
 
-	if userDefinedGateway != "" {
-		gw := types.Route{
-			Dst: net.IPNet{
-				IP:   net.IPv4zero,
-				Mask: net.IPv4Mask(0, 0, 0, 0),
-			},
-			GW: net.ParseIP(userDefinedGateway),
-		}
-		result.Routes = append(result.Routes, &gw)
-	}
-
-	if userDefinedRoutes != "" {
-		routes := strings.Split(userDefinedRoutes, ";")
-		for _, r := range routes {
-			_, dst, _ := net.ParseCIDR(strings.Split(r, ",")[0])
-			gateway := strings.Split(r, ",")[1]
-
-			gw := types.Route{
-				Dst: *dst,
-				GW: net.ParseIP(gateway),
-			}
-			result.Routes = append(result.Routes, &gw)
-		}
-	}
-	// result.Routes = append(result.Routes, ipamConf.Routes...)
-
-	if ipamConf.Service_IPNet != "" {
-		_, service_net, err := net.ParseCIDR(ipamConf.Service_IPNet)
-		if err != nil {
-			return fmt.Errorf("Invalid service cluster ip range: " + ipamConf.Service_IPNet)
-		}
-		for _, node_ip := range ipamConf.Node_IPs {
-			if subnet.Contains(net.ParseIP(node_ip)) {
-				sn := types.Route{
-					Dst: *service_net,
-					GW: net.ParseIP(node_ip),
-				}
-				result.Routes = append(result.Routes, &sn)
-				break
-			}
-			// If none of node_ip in subnet, nothing to do.
-		}
-	}
-
-	userDefinedNameserver  :=  annot["cni.daocloud.io/nameserver"]
-	userDefinedDomain      :=  annot["cni.daocloud.io/domain"]
-	userDefinedSearch      :=  annot["cni.daocloud.io/search"]
-	userDefinedOptions     :=  annot["cni.daocloud.io/options"]
-	dns, err := generateDNS(userDefinedNameserver, userDefinedDomain, userDefinedSearch, userDefinedOptions)
-	result.DNS = *dns
-
-	alloc := allocator.NewAnchorAllocator(subnet, store, string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), app, service)
-
-	ipConf, err := alloc.Get(args.ContainerID)
+func addDirect(ipamConf *allocator.IPAMConfig, args *skel.CmdArgs) (*current.Result, error) {
+	store, err := plugin.NewStore(ipamConf)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer store.Close()
 
-	// Below here, if error, we should call store.Release(args.ContainerID) to release the IP written to database.
-	if userDefinedGateway == "" {
-		gw := types.Route{
-			Dst: net.IPNet{
-				IP:   net.IPv4zero,
-				Mask: net.IPv4Mask(0, 0, 0, 0),
-			},
-			GW: ipConf.Gateway,
-		}
-		result.Routes = append(result.Routes, &gw)
+	k8sClient, err := k8s.NewK8sClient(ipamConf.Kubernetes, ipamConf.Policy)
+	if err != nil {
+		return nil, err
 	}
-	result.IPs = append(result.IPs, ipConf)
-
-	return types.PrintResult(result, confVersion)
+	return plugin.Add(ipamConf, args.ContainerID, args.Args, store, k8sClient)
 }
 
 func cmdDel(args *skel.CmdArgs) error {
@@ -184,20 +98,15 @@ func cmdDel(args *skel.CmdArgs) error {
 		return err
 	}
 
-	tlsInfo := &transport.TLSInfo{
-		CertFile:      ipamConf.CertFile,
-		KeyFile:       ipamConf.KeyFile,
-		TrustedCAFile: ipamConf.TrustedCAFile,
+	err = agent.NewClient(ipamConf.AgentSocket).Release(agentRequest(args))
+	if err != agent.ErrUnavailable {
+		return err
 	}
-	tlsConfig, _ := tlsInfo.ClientConfig()
 
-	store, err := etcd.New(ipamConf.Name, strings.Split(ipamConf.Endpoints, ","), tlsConfig)
-	defer store.Close()
+	store, err := plugin.NewStore(ipamConf)
 	if err != nil {
 		return err
 	}
-	store.Lock()
-	defer store.Unlock()
-	return store.Release(args.ContainerID)
-	// TODO: allocator and deleter.
+	defer store.Close()
+	return plugin.Del(store, args.ContainerID)
 }
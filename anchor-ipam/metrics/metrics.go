@@ -0,0 +1,122 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics exports the Prometheus metrics of anchor: the utilization
+// of the pools, read from the store at scrape time, and the allocations done
+// by this process.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "anchor"
+
+// Reason of the failures without a more precise one.
+const reasonUnknown = "Unknown"
+
+var (
+	allocations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "allocations_total",
+		Help:      "Addresses allocated to pods.",
+	})
+	allocationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "allocation_failures_total",
+		Help:      "Allocations that failed, by reason.",
+	}, []string{"reason"})
+	allocationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "allocation_duration_seconds",
+		Help:      "Time to allocate an address, lock wait included.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+	releases = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "releases_total",
+		Help:      "Addresses released.",
+	})
+	releaseFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "release_failures_total",
+		Help:      "Releases that failed.",
+	})
+	lockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "lock_wait_seconds",
+		Help:      "Time waited for the store lock.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	})
+	etcdDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "etcd_request_duration_seconds",
+		Help:      "Latency of the store requests to etcd, by operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+	}, []string{"operation"})
+)
+
+// ObserveAllocation counts an allocation that took seconds, and its failure
+// reason if err is set.
+func ObserveAllocation(seconds float64, err error) {
+	allocationDuration.Observe(seconds)
+	if err == nil {
+		allocations.Inc()
+		return
+	}
+	reason := allocator.ErrorReason(err)
+	if reason == "" {
+		reason = reasonUnknown
+	}
+	allocationFailures.WithLabelValues(reason).Inc()
+}
+
+// ObserveRelease counts a release.
+func ObserveRelease(err error) {
+	if err != nil {
+		releaseFailures.Inc()
+		return
+	}
+	releases.Inc()
+}
+
+// NewRegistry returns a registry with the metrics of this process. With a
+// store, it also has the utilization of the pools, which every scrape reads
+// from the store.
+func NewRegistry(store backend.Store) *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		allocations,
+		allocationFailures,
+		allocationDuration,
+		releases,
+		releaseFailures,
+		lockWait,
+		etcdDuration,
+	)
+	if store != nil {
+		r.MustRegister(NewPoolCollector(store))
+	}
+	return r
+}
+
+// Handler serves the metrics of a registry.
+func Handler(r *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(r, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolTotalDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pool", "total_ips"),
+		"Addresses of the pool of a namespace in a subnet, gateway excluded.",
+		[]string{"subnet", "namespace"}, nil)
+	poolUsedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pool", "used_ips"),
+		"Allocated addresses of the pool of a namespace in a subnet.",
+		[]string{"subnet", "namespace"}, nil)
+	poolFreeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pool", "free_ips"),
+		"Free addresses of the pool of a namespace in a subnet.",
+		[]string{"subnet", "namespace"}, nil)
+	workloadUsedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "workload", "used_ips"),
+		"Allocated addresses of a workload in a subnet.",
+		[]string{"subnet", "namespace", "app", "service"}, nil)
+)
+
+// PoolCollector reads the utilization of the pools from a store on every
+// scrape.
+type PoolCollector struct {
+	store backend.Store
+}
+
+func NewPoolCollector(store backend.Store) *PoolCollector {
+	return &PoolCollector{store: store}
+}
+
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolTotalDesc
+	ch <- poolUsedDesc
+	ch <- poolFreeDesc
+	ch <- workloadUsedDesc
+}
+
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	pools, err := c.store.ListPools()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(poolTotalDesc, err)
+		return
+	}
+	gateways, err := c.store.ListGateways()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(poolTotalDesc, err)
+		return
+	}
+	allocs, err := c.store.ListAllocations()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(poolUsedDesc, err)
+		return
+	}
+
+	stored, err := c.store.ListExclusions()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(poolTotalDesc, err)
+		return
+	}
+	exclusions, err := allocator.LoadExclusions(stored)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(poolTotalDesc, err)
+		return
+	}
+
+	for _, u := range allocator.PoolUsages(pools, gateways, allocs, exclusions) {
+		ch <- prometheus.MustNewConstMetric(poolTotalDesc, prometheus.GaugeValue, u.Total, u.Subnet, u.Namespace)
+		ch <- prometheus.MustNewConstMetric(poolUsedDesc, prometheus.GaugeValue, u.Used, u.Subnet, u.Namespace)
+		ch <- prometheus.MustNewConstMetric(poolFreeDesc, prometheus.GaugeValue, u.Free, u.Subnet, u.Namespace)
+	}
+	for k, used := range allocator.WorkloadUsages(gateways, allocs) {
+		ch <- prometheus.MustNewConstMetric(workloadUsedDesc, prometheus.GaugeValue, used, k.Subnet, k.Namespace, k.App, k.Service)
+	}
+}
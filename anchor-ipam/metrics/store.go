@@ -0,0 +1,279 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"net"
+	"time"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+)
+
+// instrumentedStore times the requests of a store.
+type instrumentedStore struct {
+	backend.Store
+}
+
+// InstrumentStore returns a store observing the lock wait and the latency
+// of the requests of store.
+func InstrumentStore(store backend.Store) backend.Store {
+	return &instrumentedStore{store}
+}
+
+func since(operation string, start time.Time) {
+	etcdDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func (s *instrumentedStore) Lock() error {
+	defer func(start time.Time) {
+		lockWait.Observe(time.Since(start).Seconds())
+	}(time.Now())
+	return s.Store.Lock()
+}
+
+func (s *instrumentedStore) Unlock() error {
+	defer since("unlock", time.Now())
+	return s.Store.Unlock()
+}
+
+func (s *instrumentedStore) Reserve(id string, ip net.IP, podName string, podNamespace string, app string, service string, tenant string) (bool, error) {
+	defer since("reserve", time.Now())
+	return s.Store.Reserve(id, ip, podName, podNamespace, app, service, tenant)
+}
+
+func (s *instrumentedStore) Commit(id string) error {
+	defer since("commit", time.Now())
+	return s.Store.Commit(id)
+}
+
+func (s *instrumentedStore) ListPending() ([]*backend.Allocation, error) {
+	defer since("list_pending", time.Now())
+	return s.Store.ListPending()
+}
+
+func (s *instrumentedStore) Release(id string) error {
+	defer since("release", time.Now())
+	return s.Store.Release(id)
+}
+
+func (s *instrumentedStore) GetByID(id string) (net.IP, error) {
+	defer since("get_by_id", time.Now())
+	return s.Store.GetByID(id)
+}
+
+func (s *instrumentedStore) ReleaseByIP(ip net.IP) error {
+	defer since("release_by_ip", time.Now())
+	return s.Store.ReleaseByIP(ip)
+}
+
+func (s *instrumentedStore) GetAllocatedIPs(namespace string) (string, error) {
+	defer since("get_pool", time.Now())
+	return s.Store.GetAllocatedIPs(namespace)
+}
+
+func (s *instrumentedStore) GetUsedByPod(pod string, namespace string) ([]net.IP, error) {
+	defer since("list_allocations", time.Now())
+	return s.Store.GetUsedByPod(pod, namespace)
+}
+
+func (s *instrumentedStore) GetUsedIPbyNamespace(namespace string) ([]net.IP, error) {
+	defer since("list_allocations", time.Now())
+	return s.Store.GetUsedIPbyNamespace(namespace)
+}
+
+func (s *instrumentedStore) GetUsedBySvc(app string, svc string) ([]net.IP, error) {
+	defer since("list_allocations", time.Now())
+	return s.Store.GetUsedBySvc(app, svc)
+}
+
+func (s *instrumentedStore) GetGatewayForIP(ip net.IP) (*net.IPNet, *net.IP, error) {
+	defer since("get_gateway", time.Now())
+	return s.Store.GetGatewayForIP(ip)
+}
+
+func (s *instrumentedStore) ListAllocations() ([]*backend.Allocation, error) {
+	defer since("list_allocations", time.Now())
+	return s.Store.ListAllocations()
+}
+
+func (s *instrumentedStore) ListPools() (map[string]string, error) {
+	defer since("list_pools", time.Now())
+	return s.Store.ListPools()
+}
+
+func (s *instrumentedStore) ListGateways() ([]*backend.Gateway, error) {
+	defer since("list_gateways", time.Now())
+	return s.Store.ListGateways()
+}
+
+func (s *instrumentedStore) SetPool(namespace string, ranges string) error {
+	defer since("set_pool", time.Now())
+	return s.Store.SetPool(namespace, ranges)
+}
+
+func (s *instrumentedStore) DeletePool(namespace string) error {
+	defer since("delete_pool", time.Now())
+	return s.Store.DeletePool(namespace)
+}
+
+func (s *instrumentedStore) SetGateway(subnet *net.IPNet, gateway net.IP) error {
+	defer since("set_gateway", time.Now())
+	return s.Store.SetGateway(subnet, gateway)
+}
+
+func (s *instrumentedStore) DeleteGateway(subnet *net.IPNet) error {
+	defer since("delete_gateway", time.Now())
+	return s.Store.DeleteGateway(subnet)
+}
+
+func (s *instrumentedStore) MarkExternal(ip net.IP, note string) error {
+	defer since("mark_external", time.Now())
+	return s.Store.MarkExternal(ip, note)
+}
+
+func (s *instrumentedStore) ClearExternal(ip net.IP) error {
+	defer since("clear_external", time.Now())
+	return s.Store.ClearExternal(ip)
+}
+
+func (s *instrumentedStore) ListExternal() (map[string]string, error) {
+	defer since("list_external", time.Now())
+	return s.Store.ListExternal()
+}
+
+func (s *instrumentedStore) ListExclusions() (*backend.Exclusions, error) {
+	defer since("list_exclusions", time.Now())
+	return s.Store.ListExclusions()
+}
+
+func (s *instrumentedStore) SetSubnetExclusions(subnet *net.IPNet, ranges string) error {
+	defer since("set_subnet_exclusions", time.Now())
+	return s.Store.SetSubnetExclusions(subnet, ranges)
+}
+
+func (s *instrumentedStore) DeleteSubnetExclusions(subnet *net.IPNet) error {
+	defer since("delete_subnet_exclusions", time.Now())
+	return s.Store.DeleteSubnetExclusions(subnet)
+}
+
+func (s *instrumentedStore) SetPoolExclusions(namespace string, ranges string) error {
+	defer since("set_pool_exclusions", time.Now())
+	return s.Store.SetPoolExclusions(namespace, ranges)
+}
+
+func (s *instrumentedStore) DeletePoolExclusions(namespace string) error {
+	defer since("delete_pool_exclusions", time.Now())
+	return s.Store.DeletePoolExclusions(namespace)
+}
+
+func (s *instrumentedStore) GetIndex(subnet *net.IPNet) (*backend.IPBitmap, error) {
+	defer since("get_index", time.Now())
+	return s.Store.GetIndex(subnet)
+}
+
+func (s *instrumentedStore) GetUnavailable(subnet *net.IPNet) (*backend.Unavailable, error) {
+	defer since("get_unavailable", time.Now())
+	return s.Store.GetUnavailable(subnet)
+}
+
+func (s *instrumentedStore) RebuildIndex() error {
+	defer since("rebuild_index", time.Now())
+	return s.Store.RebuildIndex()
+}
+
+func (s *instrumentedStore) GetHistory(ip net.IP) ([]*backend.HistoryEntry, error) {
+	defer since("get_history", time.Now())
+	return s.Store.GetHistory(ip)
+}
+
+func (s *instrumentedStore) ListHistory() ([]*backend.HistoryEntry, error) {
+	defer since("list_history", time.Now())
+	return s.Store.ListHistory()
+}
+
+func (s *instrumentedStore) PruneHistory(before time.Time) (int, error) {
+	defer since("prune_history", time.Now())
+	return s.Store.PruneHistory(before)
+}
+
+func (s *instrumentedStore) SetHold(hold *backend.Hold) error {
+	defer since("set_hold", time.Now())
+	return s.Store.SetHold(hold)
+}
+
+func (s *instrumentedStore) DeleteHold(ip net.IP) error {
+	defer since("delete_hold", time.Now())
+	return s.Store.DeleteHold(ip)
+}
+
+func (s *instrumentedStore) ListHolds() ([]*backend.Hold, error) {
+	defer since("list_holds", time.Now())
+	return s.Store.ListHolds()
+}
+
+func (s *instrumentedStore) TransferPool(t *backend.PoolTransfer) error {
+	defer since("transfer_pool", time.Now())
+	return s.Store.TransferPool(t)
+}
+
+func (s *instrumentedStore) GetQuota(owner string) (int, error) {
+	defer since("get_quota", time.Now())
+	return s.Store.GetQuota(owner)
+}
+
+func (s *instrumentedStore) ListQuotas() (map[string]int, error) {
+	defer since("list_quotas", time.Now())
+	return s.Store.ListQuotas()
+}
+
+func (s *instrumentedStore) SetQuota(owner string, max int) error {
+	defer since("set_quota", time.Now())
+	return s.Store.SetQuota(owner, max)
+}
+
+func (s *instrumentedStore) DeleteQuota(owner string) error {
+	defer since("delete_quota", time.Now())
+	return s.Store.DeleteQuota(owner)
+}
+
+func (s *instrumentedStore) ListTenants() (map[string]string, error) {
+	defer since("list_tenants", time.Now())
+	return s.Store.ListTenants()
+}
+
+func (s *instrumentedStore) SetTenant(name string, description string) error {
+	defer since("set_tenant", time.Now())
+	return s.Store.SetTenant(name, description)
+}
+
+func (s *instrumentedStore) DeleteTenant(name string) error {
+	defer since("delete_tenant", time.Now())
+	return s.Store.DeleteTenant(name)
+}
+
+func (s *instrumentedStore) ListTenantBindings() (map[string]string, error) {
+	defer since("list_tenant_bindings", time.Now())
+	return s.Store.ListTenantBindings()
+}
+
+func (s *instrumentedStore) BindNamespace(namespace string, tenant string) error {
+	defer since("bind_namespace", time.Now())
+	return s.Store.BindNamespace(namespace, tenant)
+}
+
+func (s *instrumentedStore) UnbindNamespace(namespace string) error {
+	defer since("unbind_namespace", time.Now())
+	return s.Store.UnbindNamespace(namespace)
+}
@@ -0,0 +1,176 @@
+// Copyright 2016 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+)
+
+// Sources of the DNS settings of a pod, in the order of precedence used when
+// "dns_policy" isn't set.
+const (
+	dnsFromAnnotations = "annotations"
+	dnsFromNetwork     = "network"
+	dnsFromResolvConf  = "resolvConf"
+)
+
+var defaultDNSPolicy = []string{dnsFromAnnotations, dnsFromNetwork, dnsFromResolvConf}
+
+// resolveDNS builds the DNS settings of a pod from its annotations, the "dns"
+// of the network config and the host resolvConf file, merged in the order of
+// ipamConf.DNSPolicy.
+func resolveDNS(ipamConf *allocator.IPAMConfig, annot map[string]string) (*types.DNS, error) {
+	policy := ipamConf.DNSPolicy
+	if len(policy) == 0 {
+		policy = defaultDNSPolicy
+	}
+
+	sources := []*types.DNS{}
+	for _, source := range policy {
+		switch source {
+		case dnsFromAnnotations:
+			dns, err := generateDNS(annot["cni.daocloud.io/nameserver"], annot["cni.daocloud.io/domain"],
+				annot["cni.daocloud.io/search"], annot["cni.daocloud.io/options"])
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, dns)
+		case dnsFromNetwork:
+			sources = append(sources, &ipamConf.NetworkDNS)
+		case dnsFromResolvConf:
+			if ipamConf.ResolvConf == "" {
+				continue
+			}
+			dns, err := parseResolvConf(ipamConf.ResolvConf)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read resolvConf %q: %v", ipamConf.ResolvConf, err)
+			}
+			sources = append(sources, dns)
+		default:
+			return nil, fmt.Errorf("unknown dns_policy source %q", source)
+		}
+	}
+	return mergeDNS(sources...)
+}
+
+// mergeDNS merges DNS settings, the first source taking precedence. The
+// domain is the first one set. Nameservers, search domains and options are
+// concatenated without duplicates, and an option only appears once whatever
+// its value, such as "ndots:2". Nameservers must be IP addresses.
+func mergeDNS(sources ...*types.DNS) (*types.DNS, error) {
+	dns := types.DNS{}
+	seen := map[string]bool{}
+	for _, source := range sources {
+		if dns.Domain == "" {
+			dns.Domain = source.Domain
+		}
+		for _, server := range source.Nameservers {
+			ip := net.ParseIP(strings.TrimSpace(server))
+			if ip == nil {
+				return nil, fmt.Errorf("invalid nameserver %q", server)
+			}
+			if key := "nameserver " + ip.String(); !seen[key] {
+				seen[key] = true
+				dns.Nameservers = append(dns.Nameservers, ip.String())
+			}
+		}
+		for _, search := range source.Search {
+			search = strings.TrimSpace(search)
+			if key := "search " + search; search != "" && !seen[key] {
+				seen[key] = true
+				dns.Search = append(dns.Search, search)
+			}
+		}
+		for _, option := range source.Options {
+			option = strings.TrimSpace(option)
+			if key := "option " + strings.SplitN(option, ":", 2)[0]; option != "" && !seen[key] {
+				seen[key] = true
+				dns.Options = append(dns.Options, option)
+			}
+		}
+	}
+	return &dns, nil
+}
+
+func generateDNS(nameserver string, domain string, search string, options string) (*types.DNS, error) {
+	dns := types.DNS{}
+	if nameserver != "" {
+		servers := strings.Split(nameserver, ",")
+		dns.Nameservers = append(dns.Nameservers, servers...)
+	}
+
+	if domain != "" {
+		dns.Domain = domain
+	}
+
+	if search != "" {
+		searches := strings.Split(search, ",")
+		dns.Search = append(dns.Search, searches...)
+	}
+	if options != "" {
+		ops := strings.Split(options, ",")
+		dns.Options = append(dns.Options, ops...)
+	}
+	return &dns, nil
+}
+
+// parseResolvConf parses an existing resolv.conf in to a DNS struct
+func parseResolvConf(filename string) (*types.DNS, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	dns := types.DNS{}
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := scanner.Text()
+		line = strings.TrimSpace(line)
+
+		// Skip comments, empty lines
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			dns.Nameservers = append(dns.Nameservers, fields[1])
+		case "domain":
+			dns.Domain = fields[1]
+		case "search":
+			dns.Search = append(dns.Search, fields[1:]...)
+		case "options":
+			dns.Options = append(dns.Options, fields[1:]...)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &dns, nil
+}
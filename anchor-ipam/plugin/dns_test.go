@@ -12,13 +12,14 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package main
+package plugin
 
 import (
 	"io/ioutil"
 	"os"
 
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -78,3 +79,43 @@ func parse(contents string) (*types.DNS, error) {
 
 	return parseResolvConf(f.Name())
 }
+
+var _ = Describe("merging DNS settings", func() {
+	It("keeps the domain of the first source and dedupes the rest", func() {
+		dns, err := mergeDNS(
+			&types.DNS{Nameservers: []string{"10.0.0.10"}, Search: []string{"ns.svc.cluster.local"}, Options: []string{"ndots:5"}},
+			&types.DNS{Nameservers: []string{"10.0.0.10", "192.0.2.53"}, Domain: "example.com", Options: []string{"ndots:2", "rotate"}},
+			&types.DNS{Domain: "example.org", Search: []string{"ns.svc.cluster.local", "example.org"}},
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*dns).To(Equal(types.DNS{
+			Nameservers: []string{"10.0.0.10", "192.0.2.53"},
+			Domain:      "example.com",
+			Search:      []string{"ns.svc.cluster.local", "example.org"},
+			Options:     []string{"ndots:5", "rotate"},
+		}))
+	})
+
+	It("rejects nameservers that aren't IP addresses", func() {
+		_, err := mergeDNS(&types.DNS{Nameservers: []string{"dns.example.com"}})
+		Expect(err).To(MatchError(`invalid nameserver "dns.example.com"`))
+	})
+
+	It("follows the configured precedence", func() {
+		conf := &allocator.IPAMConfig{
+			DNSPolicy:  []string{"network", "annotations"},
+			NetworkDNS: types.DNS{Nameservers: []string{"192.0.2.1"}, Domain: "network.local"},
+		}
+		dns, err := resolveDNS(conf, map[string]string{
+			"cni.daocloud.io/nameserver": "192.0.2.2",
+			"cni.daocloud.io/domain":     "pod.local",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dns.Nameservers).To(Equal([]string{"192.0.2.1", "192.0.2.2"}))
+		Expect(dns.Domain).To(Equal("network.local"))
+
+		conf.DNSPolicy = []string{"dhcp"}
+		_, err = resolveDNS(conf, nil)
+		Expect(err).To(MatchError(`unknown dns_policy source "dhcp"`))
+	})
+})
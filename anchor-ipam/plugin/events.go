@@ -0,0 +1,41 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+	"github.com/daocloud/anchor/anchor-ipam/k8s"
+)
+
+// Reason of the events about failures without a more precise one.
+const reasonAllocationFailed = "AllocationFailed"
+
+// recordAllocFailure makes an allocation failure visible with
+// `kubectl describe`: on the pod, and also on its namespace when the pool of
+// the namespace is the problem. Failing to record is not an error, the
+// failure is reported to the kubelet anyway.
+func recordAllocFailure(client *k8s.Client, podName, namespace, node string, err error) {
+	reason, message := reasonAllocationFailed, err.Error()
+	if allocErr, ok := err.(*allocator.AllocError); ok {
+		reason, message = allocErr.Reason, allocErr.Message
+	}
+
+	k8s.RecordPodEvent(client, podName, namespace, node, reason, message)
+
+	switch reason {
+	case allocator.ReasonPoolExhausted, allocator.ReasonNoPool, allocator.ReasonQuotaExceeded:
+		k8s.RecordNamespaceEvent(client, namespace, node, reason, message)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+	"github.com/daocloud/anchor/anchor-ipam/k8s"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("allocation failure events", func() {
+	var client *k8s.Client
+
+	BeforeEach(func() {
+		client = &k8s.Client{Interface: fake.NewSimpleClientset(
+			&corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "web-0", Namespace: "team-a", UID: "pod-uid"}},
+			&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "team-a", UID: "ns-uid"}},
+		)}
+	})
+
+	// events returns the reasons of the events by kind of object.
+	events := func() map[string][]string {
+		list, err := client.CoreV1().Events("team-a").List(v1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		ret := map[string][]string{}
+		for _, e := range list.Items {
+			ret[e.InvolvedObject.Kind] = append(ret[e.InvolvedObject.Kind], e.Reason)
+		}
+		return ret
+	}
+
+	It("records an exceeded quota on the pod and its namespace", func() {
+		err := &allocator.AllocError{Reason: allocator.ReasonQuotaExceeded, Message: "namespace team-a reached its quota of 2 IPs"}
+		recordAllocFailure(client, "web-0", "team-a", "node-1", err)
+
+		Expect(events()).To(Equal(map[string][]string{
+			"Pod":       {allocator.ReasonQuotaExceeded},
+			"Namespace": {allocator.ReasonQuotaExceeded},
+		}))
+	})
+
+	It("records the other failures on the pod only", func() {
+		recordAllocFailure(client, "web-0", "team-a", "node-1", &allocator.AllocError{Reason: allocator.ReasonTenantDenied})
+		recordAllocFailure(client, "web-0", "team-a", "node-1", errors.New("etcd is unreachable"))
+
+		recorded := events()
+		Expect(recorded).To(HaveLen(1))
+		Expect(recorded["Pod"]).To(ConsistOf(allocator.ReasonTenantDenied, reasonAllocationFailed))
+	})
+})
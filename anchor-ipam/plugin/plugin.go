@@ -0,0 +1,255 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package plugin is what anchor-ipam does on ADD and DEL, shared by the CNI
+// binary and the anchor agent.
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/coreos/etcd/pkg/transport"
+	"github.com/daocloud/anchor/anchor-ipam/backend"
+	"github.com/daocloud/anchor/anchor-ipam/backend/allocator"
+	"github.com/daocloud/anchor/anchor-ipam/backend/etcd"
+	"github.com/daocloud/anchor/anchor-ipam/k8s"
+)
+
+// NewStore connects to the etcd of the IPAM config, under the key prefix of
+// its network. The history of the addresses records the node of the config.
+func NewStore(ipamConf *allocator.IPAMConfig) (*etcd.Store, error) {
+	tlsInfo := &transport.TLSInfo{
+		CertFile:      ipamConf.CertFile,
+		KeyFile:       ipamConf.KeyFile,
+		TrustedCAFile: ipamConf.TrustedCAFile,
+	}
+	tlsConfig, _ := tlsInfo.ClientConfig()
+
+	store, err := etcd.New(ipamConf.EtcdPrefix(), strings.Split(ipamConf.Endpoints, ","), tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	store.Node = ipamConf.Kubernetes.NodeName
+	if ipamConf.HistoryRetentionHours > 0 {
+		store.HistoryRetention = time.Duration(ipamConf.HistoryRetentionHours) * time.Hour
+	}
+	return store, nil
+}
+
+// networkAnnotations are the annotations of a pod read by Add, which
+// default to those of its workload and namespace.
+var networkAnnotations = []string{
+	"cni.daocloud.io/subnet",
+	"cni.daocloud.io/routes",
+	"cni.daocloud.io/gateway",
+	"cni.daocloud.io/currentUser",
+	"cni.daocloud.io/nameserver",
+	"cni.daocloud.io/domain",
+	"cni.daocloud.io/search",
+	"cni.daocloud.io/options",
+}
+
+// Add allocates an address to the pod of a container, and returns it with
+// the routes and DNS of the pod. cniArgs holds the pod name and namespace.
+func Add(ipamConf *allocator.IPAMConfig, containerID string, cniArgs string, store backend.Store, k8sClient *k8s.Client) (*current.Result, error) {
+	result := &current.Result{}
+
+	// Get annotations of the pod, such as ipAddrs and current user.
+	// 1. The k8s_client is given by the caller, fresh or long-lived.
+
+	// 2. Get K8S_POD_NAME and K8S_POD_NAMESPACE.
+	k8sArgs := k8s.K8sArgs{}
+	if err := types.LoadArgs(cniArgs, &k8sArgs); err != nil {
+		return nil, err
+	}
+	if k8sArgs.ANCHOR_COMMIT {
+		return commit(store, containerID)
+	}
+
+	// 3. Get annotations from k8s_client via K8S_POD_NAME and K8S_POD_NAMESPACE.
+	label, annot, err := k8s.GetK8sPodInfo(k8sClient, string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE))
+	if err != nil {
+		return nil, fmt.Errorf("Error while read annotaions for pod" + err.Error())
+	}
+
+	// Annotations missing on the pod default to those of its workload and namespace.
+	annot = k8s.InheritAnnotations(k8sClient, string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), annot, networkAnnotations, ipamConf.WorkloadDefaults)
+
+	userDefinedSubnet := annot["cni.daocloud.io/subnet"]
+	userDefinedRoutes := annot["cni.daocloud.io/routes"]
+	userDefinedGateway := annot["cni.daocloud.io/gateway"]
+	// The tenant whose pool the pod asks for, see allocator.ResolveTenant.
+	currentUser := annot["cni.daocloud.io/currentUser"]
+
+	// app := label["io.daocloud.dce.app"]
+	app := label["dce.daocloud.io/app"]
+	if app == "" {
+		app = "unknown"
+	}
+	service, err := k8s.ResourceControllerName(k8sClient, string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE))
+
+	if service == "" {
+		service = backend.UnknownService
+	}
+
+	if userDefinedSubnet == "" {
+		return nil, fmt.Errorf("No ip found for pod %s: cni.daocloud.io/subnet is set neither on the pod nor on namespace %s",
+			string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE))
+	}
+
+	_, subnet, err := net.ParseCIDR(userDefinedSubnet)
+	if err != nil {
+		return nil, err
+	}
+
+	if userDefinedGateway != "" {
+		gw := types.Route{
+			Dst: net.IPNet{
+				IP:   net.IPv4zero,
+				Mask: net.IPv4Mask(0, 0, 0, 0),
+			},
+			GW: net.ParseIP(userDefinedGateway),
+		}
+		result.Routes = append(result.Routes, &gw)
+	}
+
+	if userDefinedRoutes != "" {
+		routes := strings.Split(userDefinedRoutes, ";")
+		for _, r := range routes {
+			_, dst, _ := net.ParseCIDR(strings.Split(r, ",")[0])
+			gateway := strings.Split(r, ",")[1]
+
+			gw := types.Route{
+				Dst: *dst,
+				GW:  net.ParseIP(gateway),
+			}
+			result.Routes = append(result.Routes, &gw)
+		}
+	}
+	// result.Routes = append(result.Routes, ipamConf.Routes...)
+
+	if ipamConf.Service_IPNet != "" {
+		_, service_net, err := net.ParseCIDR(ipamConf.Service_IPNet)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid service cluster ip range: " + ipamConf.Service_IPNet)
+		}
+		for _, node_ip := range ipamConf.Node_IPs {
+			if subnet.Contains(net.ParseIP(node_ip)) {
+				sn := types.Route{
+					Dst: *service_net,
+					GW:  net.ParseIP(node_ip),
+				}
+				result.Routes = append(result.Routes, &sn)
+				break
+			}
+			// If none of node_ip in subnet, nothing to do.
+		}
+	}
+
+	dns, err := resolveDNS(ipamConf, annot)
+	if err != nil {
+		return nil, err
+	}
+	result.DNS = *dns
+
+	if conflict := k8sArgs.ANCHOR_CONFLICT_IP; conflict != nil {
+		note := fmt.Sprintf("in use on the network, found when allocating to pod %s/%s", k8sArgs.K8S_POD_NAMESPACE, k8sArgs.K8S_POD_NAME)
+		if err := store.MarkExternal(conflict, note); err != nil {
+			return nil, err
+		}
+	}
+
+	alloc := allocator.NewAnchorAllocator(subnet, store, string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), app, service, currentUser)
+
+	ipConf, err := alloc.Get(containerID)
+	if err != nil {
+		recordAllocFailure(k8sClient, string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), ipamConf.Kubernetes.NodeName, err)
+		return nil, err
+	}
+
+	// Below here, the reservation expires on errors.
+	if userDefinedGateway == "" {
+		gw := types.Route{
+			Dst: net.IPNet{
+				IP:   net.IPv4zero,
+				Mask: net.IPv4Mask(0, 0, 0, 0),
+			},
+			GW: ipConf.Gateway,
+		}
+		result.Routes = append(result.Routes, &gw)
+	}
+	result.IPs = append(result.IPs, ipConf)
+
+	// The reservation expires unless committed once the main plugin
+	// completed the ADD, see commit.
+	if ipamConf.CommitOnAdd {
+		store.Lock()
+		defer store.Unlock()
+		if err := store.Commit(containerID); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// commit makes the reservation of a container permanent, and returns its
+// address. The main plugin asks for it once the pod is up, with a second ADD
+// setting ANCHOR_COMMIT, or with a CHECK, so that the reservation of a
+// failed ADD expires even when no DEL follows.
+func commit(store backend.Store, containerID string) (*current.Result, error) {
+	store.Lock()
+	defer store.Unlock()
+	if err := store.Commit(containerID); err != nil {
+		return nil, err
+	}
+	addr, err := store.GetByID(containerID)
+	if err != nil {
+		return nil, err
+	}
+	if addr == nil {
+		return nil, fmt.Errorf("no IP allocated to container %s", containerID)
+	}
+	subnet, gw, err := store.GetGatewayForIP(addr)
+	if err != nil {
+		return nil, err
+	}
+	version := "4"
+	if addr.To4() == nil {
+		version = "6"
+	}
+	return &current.Result{IPs: []*current.IPConfig{{
+		Version: version,
+		Address: net.IPNet{IP: addr, Mask: subnet.Mask},
+		Gateway: *gw,
+	}}}, nil
+}
+
+// Del releases the address of a container.
+func Del(store backend.Store, containerID string) error {
+	store.Lock()
+	defer store.Unlock()
+	return store.Release(containerID)
+}
+
+// Check returns an error if the container has no address, and commits its
+// reservation otherwise, see commit.
+func Check(store backend.Store, containerID string) error {
+	_, err := commit(store, containerID)
+	return err
+}
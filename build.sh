@@ -2,6 +2,9 @@
 
 # TODO: We should not build octopus at that directory.
 cd anchor-ipam; GOOS=linux go build
+GOOS=linux go build -o anchor-agent ./cmd/anchor-agent
+GOOS=linux go build -o anchorctl ./cmd/anchorctl
+GOOS=linux go build -o anchor-api ./cmd/anchor-api
 cd ..; cp -r octopus ../../containernetworking/plugins/plugins/main
 cd ../../containernetworking/plugins && ./build.sh
 cd -; cp ../../containernetworking/plugins/bin/octopus anchor-ipam
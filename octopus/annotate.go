@@ -0,0 +1,51 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/plugins/plugins/main/octopus/k8s"
+	"k8s.io/client-go/kubernetes"
+)
+
+// allocation describes the pod interface octopus configured, with the
+// addresses the IPAM plugin allocated for it.
+func allocation(ifName string, sc *SubnetConf, subnet string, iface *current.Interface, result *current.Result) *k8s.Allocation {
+	alloc := &k8s.Allocation{
+		Interface: ifName,
+		Master:    sc.Master,
+		Mac:       iface.Mac,
+		Subnet:    subnet,
+		IPs:       []string{},
+	}
+	for _, ipc := range result.IPs {
+		alloc.IPs = append(alloc.IPs, ipc.Address.String())
+		if alloc.Gateway == "" && ipc.Gateway != nil {
+			alloc.Gateway = ipc.Gateway.String()
+		}
+	}
+	return alloc
+}
+
+func annotatePod(client *kubernetes.Clientset, args k8s.K8sArgs, alloc *k8s.Allocation) error {
+	value, err := json.Marshal(alloc)
+	if err != nil {
+		return err
+	}
+	return k8s.AnnotatePod(client, string(args.K8S_POD_NAME), string(args.K8S_POD_NAMESPACE),
+		map[string]string{k8s.AllocatedAnnotation: string(value)})
+}
@@ -0,0 +1,237 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"syscall"
+
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+)
+
+func bridgeName(master netlink.Link) string {
+	return fmt.Sprintf("octbr%d", master.Attrs().Index)
+}
+
+// ensureBridge returns the bridge of the subnet, creating it when missing,
+// and makes sure the master is enslaved to it.
+func ensureBridge(conf *NetConf, sc *SubnetConf) (*netlink.Bridge, error) {
+	m, err := netlink.LinkByName(sc.Master)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup master %q: %v", sc.Master, err)
+	}
+
+	// The master found by discovery is the bridge itself once the addresses
+	// of the node are moved to it, and the kernel refuses to enslave a
+	// bridge to another: pods join that bridge, or that of a master already
+	// enslaved, instead.
+	if br, ok := m.(*netlink.Bridge); ok {
+		return br, setupBridge(conf, br)
+	}
+	if sc.Bridge == "" && m.Attrs().MasterIndex != 0 {
+		l, err := netlink.LinkByIndex(m.Attrs().MasterIndex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lookup the master of %q: %v", sc.Master, err)
+		}
+		if br, ok := l.(*netlink.Bridge); ok {
+			return br, setupBridge(conf, br)
+		}
+	}
+
+	name := sc.Bridge
+	if name == "" {
+		name = bridgeName(m)
+	}
+
+	br := &netlink.Bridge{
+		LinkAttrs: netlink.LinkAttrs{
+			Name: name,
+			MTU:  conf.MTU,
+			// Let the kernel use the default txqueuelen, leaving it unset
+			// means 0 for bridges.
+			TxQLen: -1,
+		},
+	}
+	if err := netlink.LinkAdd(br); err != nil && err != syscall.EEXIST {
+		return nil, fmt.Errorf("failed to create bridge %q: %v", name, err)
+	}
+
+	l, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup bridge %q: %v", name, err)
+	}
+	br, ok := l.(*netlink.Bridge)
+	if !ok {
+		return nil, fmt.Errorf("%q already exists but is not a bridge", name)
+	}
+
+	if err := setupBridge(conf, br); err != nil {
+		return nil, err
+	}
+
+	if m.Attrs().MasterIndex != br.Attrs().Index {
+		if err := enslave(m, br); err != nil {
+			return nil, err
+		}
+		log.Printf("enslaved %s to bridge %s", sc.Master, name)
+	}
+	return br, nil
+}
+
+// enslave attaches the master to the bridge, and moves the addresses and
+// routes of the node on the master to the bridge, where they keep working.
+// The bridge takes the MAC of the master, so that the neighbours of the node
+// don't notice. Everything is undone if a step fails.
+func enslave(m netlink.Link, br *netlink.Bridge) error {
+	name, brName := m.Attrs().Name, br.Attrs().Name
+
+	addrList, err := netlink.AddrList(m, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list addresses of %q: %v", name, err)
+	}
+	addrs := []netlink.Addr{}
+	for _, a := range addrList {
+		// Link-local addresses belong to the link and aren't moved.
+		if a.Scope == int(netlink.SCOPE_UNIVERSE) {
+			addrs = append(addrs, a)
+		}
+	}
+	// The routes the kernel made for the addresses come back with them on
+	// the bridge, the others, such as the default route, are moved.
+	routeList, err := netlink.RouteList(m, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list routes of %q: %v", name, err)
+	}
+	routes := []netlink.Route{}
+	for _, r := range routeList {
+		if r.Protocol != syscall.RTPROT_KERNEL {
+			routes = append(routes, r)
+		}
+	}
+
+	if err := netlink.LinkSetHardwareAddr(br, m.Attrs().HardwareAddr); err != nil {
+		return fmt.Errorf("failed to set the MAC of bridge %q: %v", brName, err)
+	}
+	if err := netlink.LinkSetMaster(m, br); err != nil {
+		return fmt.Errorf("failed to enslave %q to bridge %q: %v", name, brName, err)
+	}
+
+	moved := []netlink.Addr{}
+	rollback := func() {
+		for _, a := range moved {
+			netlink.AddrDel(br, &netlink.Addr{IPNet: a.IPNet})
+		}
+		netlink.LinkSetNoMaster(m)
+		for _, a := range moved {
+			netlink.AddrAdd(m, &netlink.Addr{IPNet: a.IPNet, Label: a.Label, Broadcast: a.Broadcast})
+		}
+		for i := range routes {
+			netlink.RouteReplace(&routes[i])
+		}
+	}
+
+	for _, a := range addrs {
+		if err := netlink.AddrDel(m, &a); err != nil {
+			rollback()
+			return fmt.Errorf("failed to remove %s from %q: %v", a.IPNet, name, err)
+		}
+		moved = append(moved, a)
+		if err := netlink.AddrAdd(br, &netlink.Addr{IPNet: a.IPNet, Broadcast: a.Broadcast}); err != nil {
+			rollback()
+			return fmt.Errorf("failed to move %s to bridge %q: %v", a.IPNet, brName, err)
+		}
+	}
+	for _, r := range routes {
+		r.LinkIndex = br.Attrs().Index
+		if err := netlink.RouteReplace(&r); err != nil {
+			rollback()
+			return fmt.Errorf("failed to move route %s to bridge %q: %v", r, brName, err)
+		}
+	}
+	return nil
+}
+
+// setupBridge sets the bridge up, in promiscuous mode if configured.
+func setupBridge(conf *NetConf, br *netlink.Bridge) error {
+	if conf.PromiscMode {
+		if err := netlink.SetPromiscOn(br); err != nil {
+			return fmt.Errorf("failed to set promisc on bridge %q: %v", br.Attrs().Name, err)
+		}
+	}
+	if err := netlink.LinkSetUp(br); err != nil {
+		return fmt.Errorf("failed to set bridge %q up: %v", br.Attrs().Name, err)
+	}
+	return nil
+}
+
+// createBridgeVeth attaches the pod to the bridge of its subnet with a veth
+// pair, named ifName on the pod side.
+func createBridgeVeth(conf *NetConf, ifName string, netns ns.NetNS, sc *SubnetConf) (*current.Interface, error) {
+	// Concurrent ADDs would move the addresses of the master at once.
+	state, err := openHostState(conf.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	br, err := ensureBridge(conf, sc)
+	state.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	hostNS, err := ns.GetCurrentNS()
+	if err != nil {
+		return nil, err
+	}
+	defer hostNS.Close()
+
+	contIface := &current.Interface{}
+	hostName := ""
+	err = netns.Do(func(_ ns.NetNS) error {
+		hostVeth, contVeth, err := ip.SetupVeth(ifName, conf.MTU, hostNS)
+		if err != nil {
+			return err
+		}
+		hostName = hostVeth.Name
+		contIface.Name = contVeth.Name
+		contIface.Mac = contVeth.HardwareAddr.String()
+		contIface.Sandbox = netns.Path()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hostVeth, err := netlink.LinkByName(hostName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %q: %v", hostName, err)
+	}
+	if err := netlink.LinkSetMaster(hostVeth, br); err != nil {
+		return nil, fmt.Errorf("failed to connect %q to bridge %q: %v", hostName, br.Attrs().Name, err)
+	}
+	if err := netlink.LinkSetHairpin(hostVeth, conf.HairpinMode); err != nil {
+		return nil, fmt.Errorf("failed to setup hairpin mode for %q: %v", hostName, err)
+	}
+	if conf.PromiscMode {
+		if err := netlink.SetPromiscOn(hostVeth); err != nil {
+			return nil, fmt.Errorf("failed to set promisc on %q: %v", hostName, err)
+		}
+	}
+
+	return contIface, nil
+}
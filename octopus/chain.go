@@ -0,0 +1,141 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/version"
+)
+
+// In chained mode octopus runs after another plugin of a conflist, and adds
+// the pod interface as a secondary one next to what the previous plugins set
+// up, for example a macvlan besides the pod network.
+
+const defaultChainedIfName = "net1"
+
+// ifName is the name of the pod interface octopus creates. The runtime's
+// CNI_IFNAME is taken by the first plugin when chained.
+func (n *NetConf) ifName(args string) string {
+	if !n.Chained {
+		return args
+	}
+	if n.IfName != "" {
+		return n.IfName
+	}
+	return defaultChainedIfName
+}
+
+// parsePrevResult returns the result of the previous plugin, nil if there is
+// none.
+func parsePrevResult(n *NetConf) (*current.Result, error) {
+	if n.RawPrevResult == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(n.RawPrevResult)
+	if err != nil {
+		return nil, fmt.Errorf("could not serialize prevResult: %v", err)
+	}
+	r, err := version.NewResult(n.CNIVersion, data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse prevResult: %v", err)
+	}
+	return current.NewResultFromResult(r)
+}
+
+// takePrevResultIPs returns a result with the addresses of prev that aren't
+// bound to an interface in the sandbox yet, typically those allocated by an
+// IPAM-only plugin earlier in the chain.
+func takePrevResultIPs(prev *current.Result) (*current.Result, error) {
+	result := &current.Result{}
+	for _, ipc := range prev.IPs {
+		if ipc.Interface != nil && *ipc.Interface >= 0 && *ipc.Interface < len(prev.Interfaces) &&
+			prev.Interfaces[*ipc.Interface].Sandbox != "" {
+			continue
+		}
+		ip := *ipc
+		result.IPs = append(result.IPs, &ip)
+	}
+	if len(result.IPs) == 0 {
+		return nil, fmt.Errorf("prevResult has no address that isn't bound to a pod interface")
+	}
+	result.Routes = prev.Routes
+	result.DNS = prev.DNS
+	return result, nil
+}
+
+// chainedRoutes drops the default routes of octopus, the previous plugins
+// own the default route of the pod.
+func chainedRoutes(routes []*types.Route) []*types.Route {
+	ret := []*types.Route{}
+	for _, r := range routes {
+		if ones, _ := r.Dst.Mask.Size(); ones == 0 {
+			continue
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// mergeResult appends the interface and addresses octopus configured to the
+// result of the previous plugin. Addresses octopus took from prev are moved to
+// the octopus interface instead of being listed twice.
+func mergeResult(prev, own *current.Result) *current.Result {
+	merged := *prev
+	offset := len(prev.Interfaces)
+
+	merged.Interfaces = append([]*current.Interface{}, prev.Interfaces...)
+	merged.Interfaces = append(merged.Interfaces, own.Interfaces...)
+
+	merged.IPs = []*current.IPConfig{}
+	for _, ipc := range prev.IPs {
+		taken := false
+		for _, o := range own.IPs {
+			if o.Address.IP.Equal(ipc.Address.IP) {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			merged.IPs = append(merged.IPs, ipc)
+		}
+	}
+	for _, ipc := range own.IPs {
+		ip := *ipc
+		if ip.Interface != nil {
+			ip.Interface = current.Int(*ip.Interface + offset)
+		}
+		merged.IPs = append(merged.IPs, &ip)
+	}
+
+	merged.Routes = append([]*types.Route{}, prev.Routes...)
+	for _, r := range own.Routes {
+		dup := false
+		for _, p := range prev.Routes {
+			if p.Dst.String() == r.Dst.String() && p.GW.Equal(r.GW) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			merged.Routes = append(merged.Routes, r)
+		}
+	}
+	return &merged
+}
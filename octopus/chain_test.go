@@ -0,0 +1,92 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func mustIPNet(s string) net.IPNet {
+	ip, n, err := net.ParseCIDR(s)
+	Expect(err).NotTo(HaveOccurred())
+	n.IP = ip
+	return *n
+}
+
+var _ = Describe("chained octopus", func() {
+	var prev *current.Result
+
+	BeforeEach(func() {
+		prev = &current.Result{
+			CNIVersion: "0.3.1",
+			Interfaces: []*current.Interface{
+				{Name: "eth0", Sandbox: "/var/run/netns/pod"},
+			},
+			IPs: []*current.IPConfig{
+				{Version: "4", Interface: current.Int(0), Address: mustIPNet("10.244.1.5/24")},
+				{Version: "4", Address: mustIPNet("192.168.2.10/24")},
+			},
+			Routes: []*types.Route{
+				{Dst: mustIPNet("0.0.0.0/0"), GW: net.ParseIP("10.244.1.1")},
+			},
+		}
+	})
+
+	It("appends its interface and addresses after the previous ones", func() {
+		own := &current.Result{
+			Interfaces: []*current.Interface{{Name: "net1", Sandbox: "/var/run/netns/pod"}},
+			IPs: []*current.IPConfig{
+				{Version: "4", Interface: current.Int(0), Address: mustIPNet("192.168.3.20/24")},
+			},
+			Routes: chainedRoutes([]*types.Route{
+				{Dst: mustIPNet("0.0.0.0/0"), GW: net.ParseIP("192.168.3.1")},
+				{Dst: mustIPNet("172.16.0.0/16"), GW: net.ParseIP("192.168.3.1")},
+			}),
+		}
+
+		merged := mergeResult(prev, own)
+		Expect(merged.Interfaces).To(HaveLen(2))
+		Expect(merged.Interfaces[1].Name).To(Equal("net1"))
+		Expect(merged.IPs).To(HaveLen(3))
+		Expect(*merged.IPs[2].Interface).To(Equal(1))
+		Expect(merged.IPs[2].Address.IP.String()).To(Equal("192.168.3.20"))
+		Expect(merged.Routes).To(HaveLen(2))
+		Expect(merged.Routes[1].Dst.String()).To(Equal("172.16.0.0/16"))
+		// prev is left untouched
+		Expect(prev.Interfaces).To(HaveLen(1))
+	})
+
+	It("moves unbound addresses of prevResult to its interface", func() {
+		own, err := takePrevResultIPs(prev)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(own.IPs).To(HaveLen(1))
+		Expect(own.IPs[0].Address.IP.String()).To(Equal("192.168.2.10"))
+
+		own.Interfaces = []*current.Interface{{Name: "net1", Sandbox: "/var/run/netns/pod"}}
+		own.IPs[0].Interface = current.Int(0)
+
+		merged := mergeResult(prev, own)
+		Expect(merged.IPs).To(HaveLen(2))
+		Expect(merged.IPs[1].Address.IP.String()).To(Equal("192.168.2.10"))
+		Expect(*merged.IPs[1].Interface).To(Equal(1))
+		Expect(prev.IPs[1].Interface).To(BeNil())
+	})
+})
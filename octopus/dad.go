@@ -0,0 +1,239 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultDADTimeout = 500
+	defaultDADRetries = 3
+
+	// Number of ARP probes sent within the DAD timeout.
+	arpProbes = 3
+	// IPv6 DAD is done by the kernel, which takes a second by default.
+	minIPv6DADTimeout = 2 * time.Second
+)
+
+func (n *NetConf) dadTimeout() time.Duration {
+	if n.DADTimeout <= 0 {
+		return defaultDADTimeout * time.Millisecond
+	}
+	return time.Duration(n.DADTimeout) * time.Millisecond
+}
+
+func (n *NetConf) dadRetries() int {
+	if n.DADRetries <= 0 {
+		return defaultDADRetries
+	}
+	return n.DADRetries
+}
+
+// conflictArgs tells the IPAM plugin the address of the previous attempt is
+// used by another host, so that it allocates another one.
+func conflictArgs(args string, conflict net.IP) string {
+	return appendArgs(args, "ANCHOR_CONFLICT_IP="+conflict.String())
+}
+
+func htons(i uint16) uint16 {
+	return (i<<8)&0xff00 | i>>8
+}
+
+// arpProbe builds an ARP probe (RFC 5227) for target from hwAddr: a request
+// with a zero sender address, so that no neighbour cache learns from it.
+func arpProbe(hwAddr net.HardwareAddr, target net.IP) []byte {
+	frame := make([]byte, 0, 42)
+	// Ethernet header.
+	frame = append(frame, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	frame = append(frame, hwAddr...)
+	frame = append(frame, 0x08, 0x06)
+	// ARP request, Ethernet and IPv4.
+	frame = append(frame, 0x00, 0x01, 0x08, 0x00, 6, 4, 0x00, 0x01)
+	frame = append(frame, hwAddr...)
+	frame = append(frame, 0, 0, 0, 0)
+	frame = append(frame, 0, 0, 0, 0, 0, 0)
+	frame = append(frame, target.To4()...)
+	return frame
+}
+
+// arpConflict tells whether frame shows another host using target: a reply
+// or a request sent from target, or a probe for it from another host.
+func arpConflict(frame []byte, hwAddr net.HardwareAddr, target net.IP) bool {
+	if len(frame) < 42 || binary.BigEndian.Uint16(frame[12:14]) != unix.ETH_P_ARP {
+		return false
+	}
+	arp := frame[14:]
+	if binary.BigEndian.Uint16(arp[2:4]) != unix.ETH_P_IP || arp[4] != 6 || arp[5] != 4 {
+		return false
+	}
+	sha, spa, tpa := net.HardwareAddr(arp[8:14]), net.IP(arp[14:18]), net.IP(arp[24:28])
+	if bytes.Equal(sha, hwAddr) {
+		return false
+	}
+	if spa.Equal(target.To4()) {
+		return true
+	}
+	op := binary.BigEndian.Uint16(arp[6:8])
+	return op == 1 && spa.Equal(net.IPv4zero.To4()) && tpa.Equal(target.To4())
+}
+
+// probeARP sends ARP probes for addr out of ifName, and tells whether another
+// host answered within timeout. It runs in the netns of ifName.
+func probeARP(ifName string, addr net.IP, timeout time.Duration) (bool, error) {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up %q: %v", ifName, err)
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return false, fmt.Errorf("failed to set %q up: %v", ifName, err)
+	}
+	hwAddr := link.Attrs().HardwareAddr
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return false, fmt.Errorf("failed to open ARP socket: %v", err)
+	}
+	defer unix.Close(fd)
+
+	sa := &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: link.Attrs().Index}
+	if err := unix.Bind(fd, sa); err != nil {
+		return false, fmt.Errorf("failed to bind ARP socket to %q: %v", ifName, err)
+	}
+
+	probe := arpProbe(hwAddr, addr)
+	interval := timeout / arpProbes
+	buf := make([]byte, 1500)
+	for i := 0; i < arpProbes; i++ {
+		if err := unix.Sendto(fd, probe, 0, sa); err != nil {
+			return false, fmt.Errorf("failed to send ARP probe for %s: %v", addr, err)
+		}
+		deadline := time.Now().Add(interval)
+		for {
+			left := time.Until(deadline)
+			if left <= 0 {
+				break
+			}
+			tv := unix.NsecToTimeval(left.Nanoseconds())
+			if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+				return false, err
+			}
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				return false, fmt.Errorf("failed to read ARP replies for %s: %v", addr, err)
+			}
+			if arpConflict(buf[:n], hwAddr, addr) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// waitIPv6DAD waits for the kernel to finish the duplicate address detection
+// of ips on ifName, and returns the first one that failed. Other addresses,
+// such as the link-local one, aren't waited for, so it returns at once when
+// ips has no IPv6 address. It runs in the netns of ifName.
+func waitIPv6DAD(ifName string, ips []net.IP, timeout time.Duration) (net.IP, error) {
+	wanted := []net.IP{}
+	for _, ip := range ips {
+		if ip.To4() == nil {
+			wanted = append(wanted, ip)
+		}
+	}
+	if len(wanted) == 0 {
+		return nil, nil
+	}
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up %q: %v", ifName, err)
+	}
+	if timeout < minIPv6DADTimeout {
+		timeout = minIPv6DADTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of %q: %v", ifName, err)
+		}
+		tentative := false
+		for _, addr := range addrs {
+			if !containsIP(wanted, addr.IP) {
+				continue
+			}
+			if addr.Flags&unix.IFA_F_DADFAILED != 0 {
+				return addr.IP, nil
+			}
+			if addr.Flags&unix.IFA_F_TENTATIVE != 0 {
+				tentative = true
+			}
+		}
+		if !tentative || time.Now().After(deadline) {
+			return nil, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// flushIface removes the addresses and routes set by ipam.ConfigureIface, so
+// that it may run again. It runs in the netns of ifName.
+func flushIface(ifName string) error {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to look up %q: %v", ifName, err)
+	}
+	routes, err := netlink.RouteList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+	for _, route := range routes {
+		netlink.RouteDel(&route)
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		// Keep the IPv6 link local address of the interface.
+		if addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if err := netlink.AddrDel(link, &addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("duplicate address detection", func() {
+	ours, _ := net.ParseMAC("02:42:0a:00:01:02")
+	theirs, _ := net.ParseMAC("02:42:0a:00:01:03")
+	target := net.ParseIP("10.0.1.2")
+
+	// reply is the answer of theirs to a probe for target.
+	reply := func() []byte {
+		frame := arpProbe(theirs, target)
+		copy(frame[0:6], ours)
+		frame[21] = 2
+		copy(frame[28:32], target.To4())
+		copy(frame[32:38], ours)
+		return frame
+	}
+
+	It("probes with a zero sender address", func() {
+		frame := arpProbe(ours, target)
+		Expect(frame).To(HaveLen(42))
+		Expect(frame[28:32]).To(Equal([]byte{0, 0, 0, 0}))
+		Expect(net.IP(frame[38:42]).Equal(target)).To(BeTrue())
+	})
+
+	It("sees a conflict in replies and probes of other hosts only", func() {
+		Expect(arpConflict(reply(), ours, target)).To(BeTrue())
+		Expect(arpConflict(arpProbe(theirs, target), ours, target)).To(BeTrue())
+		Expect(arpConflict(arpProbe(ours, target), ours, target)).To(BeFalse())
+		Expect(arpConflict(arpProbe(theirs, net.ParseIP("10.0.1.3")), ours, target)).To(BeFalse())
+		Expect(arpConflict(reply()[:30], ours, target)).To(BeFalse())
+	})
+
+	It("tells the IPAM plugin about the conflict in its args", func() {
+		conflict := net.ParseIP("10.0.1.2")
+		Expect(conflictArgs("", conflict)).To(Equal("ANCHOR_CONFLICT_IP=10.0.1.2"))
+		Expect(conflictArgs("K8S_POD_NAME=web-0;K8S_POD_NAMESPACE=team-a", conflict)).
+			To(Equal("K8S_POD_NAME=web-0;K8S_POD_NAMESPACE=team-a;ANCHOR_CONFLICT_IP=10.0.1.2"))
+	})
+})
@@ -0,0 +1,64 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// anchorIPAM is the type of anchor-ipam, which keeps the address of an ADD
+// pending until octopus commits it.
+const anchorIPAM = "anchor-ipam"
+
+// appendArgs appends arg to CNI_ARGS.
+func appendArgs(args string, arg string) string {
+	if args == "" {
+		return arg
+	}
+	return strings.TrimSuffix(args, ";") + ";" + arg
+}
+
+// execIPAMAdd runs the ADD of the IPAM plugin with pluginArgs as its
+// CNI_ARGS, the rest of its environment being that of octopus.
+func execIPAMAdd(n *NetConf, args *skel.CmdArgs, pluginArgs string) (types.Result, error) {
+	path, err := invoke.FindInPath(n.IPAM.Type, filepath.SplitList(args.Path))
+	if err != nil {
+		return nil, err
+	}
+	return invoke.ExecPluginWithResult(path, args.StdinData, &invoke.Args{
+		Command:       "ADD",
+		ContainerID:   args.ContainerID,
+		NetNS:         args.Netns,
+		PluginArgsStr: pluginArgs,
+		IfName:        args.IfName,
+		Path:          args.Path,
+	})
+}
+
+// commitIPAM tells anchor-ipam that the pod is up, with a second ADD, so
+// that it commits the address of the container, which expires otherwise.
+// Other IPAM plugins have nothing to commit.
+func commitIPAM(n *NetConf, args *skel.CmdArgs) error {
+	if n.IPAM.Type != anchorIPAM {
+		return nil
+	}
+	_, err := execIPAMAdd(n, args, appendArgs(args.Args, "ANCHOR_COMMIT=true"))
+	return err
+}
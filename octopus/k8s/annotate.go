@@ -0,0 +1,51 @@
+// Copyright 2015 Tigera Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"encoding/json"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AllocatedAnnotation records on the pod what octopus configured for it.
+const AllocatedAnnotation = AnnotationPrefix + "allocated"
+
+// Allocation is the value of AllocatedAnnotation.
+type Allocation struct {
+	Interface string   `json:"interface"`
+	Master    string   `json:"master"`
+	Mac       string   `json:"mac"`
+	Subnet    string   `json:"subnet"`
+	IPs       []string `json:"ips"`
+	Gateway   string   `json:"gateway,omitempty"`
+}
+
+// AnnotatePod sets annotations on a pod with a merge patch, leaving the
+// other annotations alone.
+func AnnotatePod(client *kubernetes.Clientset, podName, namespace string, annotations map[string]string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.CoreV1().Pods(namespace).Patch(podName, k8stypes.MergePatchType, patch)
+	return err
+}
@@ -0,0 +1,139 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// resolveMaster returns how pods in subnet should be attached to the host.
+// The static "octopus" map in the network config wins, otherwise the host
+// links are scanned for an address in, or a route to, the subnet.
+func resolveMaster(n *NetConf, subnet string) (*SubnetConf, error) {
+	if subnet == "" {
+		return nil, fmt.Errorf("No annotation named cni.daocloud.io/subnet found")
+	}
+
+	conf := SubnetConf{}
+	if entry := n.Octopus[subnet]; entry != nil {
+		conf = *entry
+	}
+
+	if conf.Master != "" {
+		log.Printf("using master %s for subnet %s from the octopus map", conf.Master, subnet)
+		return conf.withDefaults(n)
+	}
+
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet %q: %v", subnet, err)
+	}
+
+	link, how, err := discoverMaster(ipnet)
+	if err != nil {
+		return nil, err
+	}
+	if link == nil {
+		return nil, fmt.Errorf("Master interface %s not found on this node", subnet)
+	}
+
+	conf.Master = link.Attrs().Name
+	log.Printf("discovered master %s for subnet %s by %s", conf.Master, subnet, how)
+	return conf.withDefaults(n)
+}
+
+// discoverMaster looks for the host link owning an address inside subnet, and
+// falls back to the link carrying the most specific non-default route that
+// covers subnet. The second return value says which of the two matched.
+func discoverMaster(subnet *net.IPNet) (netlink.Link, string, error) {
+	family := netlink.FAMILY_V4
+	if subnet.IP.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list host links: %v", err)
+	}
+
+	for _, link := range links {
+		if link.Attrs().Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := netlink.AddrList(link, family)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list addresses of %q: %v", link.Attrs().Name, err)
+		}
+		for _, addr := range addrs {
+			if subnet.Contains(addr.IP) {
+				link, err := lowerLink(link)
+				return link, "address " + addr.IPNet.String(), err
+			}
+		}
+	}
+
+	routes, err := netlink.RouteList(nil, family)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list host routes: %v", err)
+	}
+
+	var best *netlink.Route
+	subnetOnes, _ := subnet.Mask.Size()
+	for i, route := range routes {
+		// The default route covers everything and tells us nothing.
+		if route.Dst == nil || route.LinkIndex <= 0 {
+			continue
+		}
+		ones, _ := route.Dst.Mask.Size()
+		if ones == 0 || ones > subnetOnes || !route.Dst.Contains(subnet.IP) {
+			continue
+		}
+		if best == nil {
+			best = &routes[i]
+			continue
+		}
+		if bestOnes, _ := best.Dst.Mask.Size(); ones > bestOnes {
+			best = &routes[i]
+		}
+	}
+	if best == nil {
+		return nil, "", nil
+	}
+
+	link, err := netlink.LinkByIndex(best.LinkIndex)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to lookup link %d of route %s: %v", best.LinkIndex, best.Dst, err)
+	}
+	link, err = lowerLink(link)
+	return link, "route " + best.Dst.String(), err
+}
+
+// lowerLink returns the parent of a macvlan link, so that the host-side
+// macvlan created by install-cni.sh (acr00 and friends) resolves to the real
+// uplink rather than stacking macvlans on top of each other.
+func lowerLink(link netlink.Link) (netlink.Link, error) {
+	if _, ok := link.(*netlink.Macvlan); !ok {
+		return link, nil
+	}
+	parent, err := netlink.LinkByIndex(link.Attrs().ParentIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup parent of %q: %v", link.Attrs().Name, err)
+	}
+	return parent, nil
+}
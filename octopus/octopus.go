@@ -18,7 +18,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net"
+	"os"
 	"runtime"
 
 	"github.com/containernetworking/cni/pkg/skel"
@@ -27,9 +29,9 @@ import (
 	"github.com/containernetworking/cni/pkg/version"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
-	"github.com/containernetworking/plugins/plugins/main/octopus/k8s"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	"github.com/containernetworking/plugins/plugins/main/octopus/k8s"
 	"github.com/j-keck/arping"
 	"github.com/vishvananda/netlink"
 )
@@ -41,12 +43,40 @@ const (
 type NetConf struct {
 	types.NetConf
 	// Master string `json:"master"`
-	Mode   string `json:"mode"`
-	MTU    int    `json:"mtu"`
-	Octopus  map[string]string `json:"octopus"`
-	Kubernetes k8s.Kubernetes `json:"kubernetes"`
-	Policy     k8s.Policy     `json:"policy"`
-
+	// Link is the default link type, "macvlan", "ipvlan" or "bridge".
+	Link       string `json:"link"`
+	Mode       string `json:"mode"`
+	IpvlanMode string `json:"ipvlan_mode"`
+	MTU        int    `json:"mtu"`
+	// Used by the bridge link type.
+	HairpinMode bool                   `json:"hairpin_mode"`
+	PromiscMode bool                   `json:"promisc_mode"`
+	Octopus     map[string]*SubnetConf `json:"octopus"`
+	Kubernetes  k8s.Kubernetes         `json:"kubernetes"`
+	Policy      k8s.Policy             `json:"policy"`
+	// AnnotatePod writes what was allocated and configured back to the pod.
+	AnnotatePod bool `json:"annotate_pod"`
+	// Same as workload_defaults of anchor-ipam.
+	WorkloadDefaults bool `json:"workload_defaults"`
+	// HostShim routes the pods of this node through a host side child of
+	// the master, so that the node and the pods can reach each other.
+	HostShim bool `json:"host_shim"`
+	// Chained runs octopus after other plugins of a conflist, adding the
+	// pod interface named IfName next to theirs. With PrevResultIPs the
+	// addresses come from prevResult instead of the IPAM plugin.
+	Chained       bool                   `json:"chained"`
+	IfName        string                 `json:"if_name"`
+	PrevResultIPs bool                   `json:"prev_result_ips"`
+	RawPrevResult map[string]interface{} `json:"prevResult"`
+	// DAD checks that no other host uses the addresses before configuring
+	// them, allocating others up to DADRetries times if one does.
+	DAD        bool `json:"dad"`
+	DADTimeout int  `json:"dad_timeout_ms"`
+	DADRetries int  `json:"dad_retries"`
+	// DataDir keeps track of the host interfaces octopus creates.
+	DataDir string `json:"data_dir"`
+	// LogFile receives the plugin log, stderr is used when empty.
+	LogFile string `json:"log_file"`
 }
 
 func init() {
@@ -62,8 +92,16 @@ func loadConf(bytes []byte) (*NetConf, string, error) {
 		return nil, "", fmt.Errorf("failed to load netconf: %v", err)
 	}
 
-	if n.Octopus == nil {
-		return nil, "", fmt.Errorf(`"octopus" field is required. It specifies a list of interface name to virtualize`)
+	if n.PrevResultIPs && !n.Chained {
+		return nil, "", fmt.Errorf(`"prev_result_ips" requires "chained"`)
+	}
+
+	if n.LogFile != "" {
+		f, err := os.OpenFile(n.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to open log file %q: %v", n.LogFile, err)
+		}
+		log.SetOutput(f)
 	}
 
 	return n, n.CNIVersion, nil
@@ -84,14 +122,28 @@ func modeFromString(s string) (netlink.MacvlanMode, error) {
 	}
 }
 
-func createMacvlan(conf *NetConf, ifName string, netns ns.NetNS, master string) (*current.Interface, error) {
+func ipvlanModeFromString(s string) (netlink.IPVlanMode, error) {
+	switch s {
+	case "", "l2":
+		return netlink.IPVLAN_MODE_L2, nil
+	case "l3":
+		return netlink.IPVLAN_MODE_L3, nil
+	case "l3s":
+		return netlink.IPVLAN_MODE_L3S, nil
+	default:
+		return 0, fmt.Errorf("unknown ipvlan mode: %q", s)
+	}
+}
+
+func createMacvlan(conf *NetConf, ifName string, netns ns.NetNS, sc *SubnetConf) (*current.Interface, error) {
 	macvlan := &current.Interface{}
+	master := sc.Master
 
-	mode, err := modeFromString(conf.Mode)
+	mode, err := modeFromString(sc.Mode)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	// m, err := netlink.LinkByName(conf.Master)
 	m, err := netlink.LinkByName(master)
 	if err != nil {
@@ -152,6 +204,136 @@ func createMacvlan(conf *NetConf, ifName string, netns ns.NetNS, master string)
 	return macvlan, nil
 }
 
+func createIpvlan(conf *NetConf, ifName string, netns ns.NetNS, sc *SubnetConf) (*current.Interface, error) {
+	ipvlan := &current.Interface{}
+
+	mode, err := ipvlanModeFromString(sc.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := netlink.LinkByName(sc.Master)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup master %q: %v", sc.Master, err)
+	}
+
+	// due to kernel bug we have to create with tmpName or it might
+	// collide with the name on the host and error out
+	tmpName, err := ip.RandomVethName()
+	if err != nil {
+		return nil, err
+	}
+
+	iv := &netlink.IPVlan{
+		LinkAttrs: netlink.LinkAttrs{
+			MTU:         conf.MTU,
+			Name:        tmpName,
+			ParentIndex: m.Attrs().Index,
+			Namespace:   netlink.NsFd(int(netns.Fd())),
+		},
+		Mode: mode,
+	}
+
+	if err := netlink.LinkAdd(iv); err != nil {
+		return nil, fmt.Errorf("failed to create ipvlan: %v", err)
+	}
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		err := ip.RenameLink(tmpName, ifName)
+		if err != nil {
+			_ = netlink.LinkDel(iv)
+			return fmt.Errorf("failed to rename ipvlan to %q: %v", ifName, err)
+		}
+		ipvlan.Name = ifName
+
+		// Re-fetch ipvlan to get all properties/attributes
+		contIpvlan, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to refetch ipvlan %q: %v", ifName, err)
+		}
+		ipvlan.Mac = contIpvlan.Attrs().HardwareAddr.String()
+		ipvlan.Sandbox = netns.Path()
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ipvlan, nil
+}
+
+// createLink creates the pod interface of the link type chosen for the subnet.
+func createLink(conf *NetConf, ifName string, netns ns.NetNS, sc *SubnetConf) (*current.Interface, error) {
+	switch sc.Link {
+	case linkIpvlan:
+		return createIpvlan(conf, ifName, netns, sc)
+	case linkBridge:
+		return createBridgeVeth(conf, ifName, netns, sc)
+	default:
+		return createMacvlan(conf, ifName, netns, sc)
+	}
+}
+
+// configureIface configures the addresses of result on the pod interface.
+// With DAD, it first checks no other host uses them, and returns the first
+// one that is instead of configuring it.
+func configureIface(n *NetConf, netns ns.NetNS, ifName string, master *SubnetConf, result *current.Result) (net.IP, error) {
+	var conflict net.IP
+	err := netns.Do(func(_ ns.NetNS) error {
+		// IPv4 addresses are probed before they're configured, so that the
+		// pod never answers for an address of another host.
+		if n.DAD && master.usesARP() {
+			for _, ipc := range result.IPs {
+				if ipc.Version != "4" {
+					continue
+				}
+				used, err := probeARP(ifName, ipc.Address.IP, n.dadTimeout())
+				if err != nil {
+					return err
+				}
+				if used {
+					conflict = ipc.Address.IP
+					return nil
+				}
+			}
+		}
+
+		if err := ipam.ConfigureIface(ifName, result); err != nil {
+			return err
+		}
+
+		// The kernel does the detection of IPv6 addresses when they're added.
+		if n.DAD {
+			ips := make([]net.IP, 0, len(result.IPs))
+			for _, ipc := range result.IPs {
+				ips = append(ips, ipc.Address.IP)
+			}
+			failed, err := waitIPv6DAD(ifName, ips, n.dadTimeout())
+			if err != nil {
+				return err
+			}
+			if failed != nil {
+				conflict = failed
+				return nil
+			}
+		}
+
+		contVeth, err := net.InterfaceByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to look up %q: %v", ifName, err)
+		}
+
+		for _, ipc := range result.IPs {
+			if ipc.Version == "4" && master.usesARP() {
+				_ = arping.GratuitousArpOverIface(ipc.Address.IP, *contVeth)
+			}
+		}
+		return nil
+	})
+	return conflict, err
+}
+
 func cmdAdd(args *skel.CmdArgs) error {
 	n, cniVersion, err := loadConf(args.StdinData)
 	if err != nil {
@@ -164,6 +346,16 @@ func cmdAdd(args *skel.CmdArgs) error {
 	}
 	defer netns.Close()
 
+	var prevResult *current.Result
+	if n.Chained {
+		if prevResult, err = parsePrevResult(n); err != nil {
+			return err
+		}
+		if prevResult == nil {
+			return errors.New("chained octopus requires prevResult")
+		}
+	}
+	ifName := n.ifName(args.IfName)
 
 	// Get annotations of the pod, such as ipAddrs and current user.
 	// 1. Get conf for k8s client and create a k8s_client
@@ -183,17 +375,30 @@ func cmdAdd(args *skel.CmdArgs) error {
 	if err != nil {
 		return fmt.Errorf("Error while read annotaions for pod " + err.Error())
 	}
+	// Annotations missing on the pod default to those of its workload and namespace.
+	annot = k8s.InheritAnnotations(k8sClient, string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), annot, []string{"cni.daocloud.io/subnet"}, n.WorkloadDefaults)
 	// master := annot["cni.daocloud.io/master"]
 	subnet := annot["cni.daocloud.io/subnet"]
 
-	master := n.Octopus[subnet]
-	if master == "" {
-		if subnet == "" {
-			return fmt.Errorf("No annotation named cni.daocloud.io/subnet found")
+	master, err := resolveMaster(n, subnet)
+	if err != nil {
+		return err
+	}
+	if master.VLAN != 0 {
+		master.Master, err = setupVlan(n.DataDir, master.Master, master.VLAN, args.ContainerID)
+		if err != nil {
+			return err
 		}
-		return fmt.Errorf("Master interface %s not found on this node", subnet)
+
+		// Drop the reference to the vlan if err, so it doesn't stay forever.
+		defer func() {
+			if err != nil {
+				teardownVlans(n.DataDir, args.ContainerID)
+			}
+		}()
 	}
-	macvlanInterface, err := createMacvlan(n, args.IfName, netns, master)
+	log.Printf("attaching %s to %s as %s (mode %q)", args.ContainerID, master.Master, master.Link, master.Mode)
+	macvlanInterface, err := createLink(n, ifName, netns, master)
 	if err != nil {
 		return err
 	}
@@ -202,62 +407,114 @@ func cmdAdd(args *skel.CmdArgs) error {
 	defer func() {
 		if err != nil {
 			netns.Do(func(_ ns.NetNS) error {
-				return ip.DelLinkByName(args.IfName)
+				return ip.DelLinkByName(ifName)
 			})
 		}
 	}()
 
-	// run the IPAM plugin and get back the config to apply
-	r, err := ipam.ExecAdd(n.IPAM.Type, args.StdinData)
-	if err != nil {
-		return err
+	if !n.PrevResultIPs {
+		// Invoke ipam del if err to avoid ip leak
+		defer func() {
+			if err != nil {
+				ipam.ExecDel(n.IPAM.Type, args.StdinData)
+			}
+		}()
 	}
 
-	// Invoke ipam del if err to avoid ip leak
-	defer func() {
-		if err != nil {
-			ipam.ExecDel(n.IPAM.Type, args.StdinData)
+	var result *current.Result
+	// The CNI_ARGS of the IPAM plugin, which tell it about the conflict of
+	// the previous attempt.
+	ipamArgs := args.Args
+	for attempt := 0; ; attempt++ {
+		if n.PrevResultIPs {
+			if result, err = takePrevResultIPs(prevResult); err != nil {
+				return err
+			}
+		} else {
+			// run the IPAM plugin and get back the config to apply
+			var r types.Result
+			r, err = execIPAMAdd(n, args, ipamArgs)
+			if err != nil {
+				return err
+			}
+
+			// Convert whatever the IPAM result was into the current Result type
+			if result, err = current.NewResultFromResult(r); err != nil {
+				return err
+			}
+
+			if len(result.IPs) == 0 {
+				return errors.New("IPAM plugin returned missing IP config")
+			}
+			if n.Chained {
+				result.Routes = chainedRoutes(result.Routes)
+			}
 		}
-	}()
+		result.Interfaces = []*current.Interface{macvlanInterface}
 
-	// Convert whatever the IPAM result was into the current Result type
-	result, err := current.NewResultFromResult(r)
-	if err != nil {
-		return err
-	}
+		for _, ipc := range result.IPs {
+			// All addresses apply to the container interface
+			ipc.Interface = current.Int(0)
+		}
 
-	if len(result.IPs) == 0 {
-		return errors.New("IPAM plugin returned missing IP config")
-	}
-	result.Interfaces = []*current.Interface{macvlanInterface}
+		var conflict net.IP
+		if conflict, err = configureIface(n, netns, ifName, master, result); err != nil {
+			return err
+		}
+		if conflict == nil {
+			break
+		}
 
-	for _, ipc := range result.IPs {
-		// All addresses apply to the container macvlan interface
-		ipc.Interface = current.Int(0)
+		// The addresses of prevResult can't be replaced by another one.
+		if n.PrevResultIPs || attempt == n.dadRetries() {
+			err = fmt.Errorf("%s is already used by another host", conflict)
+			return err
+		}
+		log.Printf("%s is already used by another host, allocating another address to %s", conflict, args.ContainerID)
+		if err = netns.Do(func(_ ns.NetNS) error {
+			return flushIface(ifName)
+		}); err != nil {
+			return err
+		}
+		if err = ipam.ExecDel(n.IPAM.Type, args.StdinData); err != nil {
+			return err
+		}
+		ipamArgs = conflictArgs(args.Args, conflict)
 	}
 
-	err = netns.Do(func(_ ns.NetNS) error {
-		if err := ipam.ConfigureIface(args.IfName, result); err != nil {
+	// The host reaches bridged pods through the bridge, no shim needed.
+	if n.HostShim && master.Link != linkBridge {
+		if err = setupShim(n.DataDir, master, n.MTU, args.ContainerID, result.IPs); err != nil {
+			teardownShim(n.DataDir, args.ContainerID)
 			return err
 		}
+	}
 
-		contVeth, err := net.InterfaceByName(args.IfName)
-		if err != nil {
-			return fmt.Errorf("failed to look up %q: %v", args.IfName, err)
+	// Only once the pod is up, so that anchor-ipam frees the address of a
+	// failed ADD even without a DEL.
+	if !n.PrevResultIPs {
+		if err = commitIPAM(n, args); err != nil {
+			return err
 		}
+	}
 
-		for _, ipc := range result.IPs {
-			if ipc.Version == "4" {
-				_ = arping.GratuitousArpOverIface(ipc.Address.IP, *contVeth)
-			}
+	// anchor-ipam already merged the network DNS into its result following
+	// its dns_policy, only fall back to it for IPAM plugins that don't.
+	if isEmptyDNS(result.DNS) {
+		result.DNS = n.DNS
+	}
+
+	if n.AnnotatePod {
+		// The pod is up whatever happens here, so only log failures.
+		alloc := allocation(ifName, master, subnet, macvlanInterface, result)
+		if err := annotatePod(k8sClient, k8sArgs, alloc); err != nil {
+			log.Printf("failed to annotate pod %s/%s: %v", k8sArgs.K8S_POD_NAMESPACE, k8sArgs.K8S_POD_NAME, err)
 		}
-		return nil
-	})
-	if err != nil {
-		return err
 	}
 
-	result.DNS = n.DNS
+	if prevResult != nil {
+		result = mergeResult(prevResult, result)
+	}
 
 	return types.PrintResult(result, cniVersion)
 }
@@ -268,27 +525,40 @@ func cmdDel(args *skel.CmdArgs) error {
 		return err
 	}
 
-	err = ipam.ExecDel(n.IPAM.Type, args.StdinData)
-	if err != nil {
-		return err
-	}
-
-	if args.Netns == "" {
-		return nil
+	// The addresses belong to the previous plugin, it releases them.
+	if !n.PrevResultIPs {
+		err = ipam.ExecDel(n.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
 	}
 
-	// There is a netns so try to clean up. Delete can be called multiple times
-	// so don't return an error if the device is already removed.
-	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
-		if err := ip.DelLinkByName(args.IfName); err != nil {
-			if err != ip.ErrLinkNotFound {
-				return err
+	if args.Netns != "" {
+		// There is a netns so try to clean up. Delete can be called multiple times
+		// so don't return an error if the device is already removed.
+		err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+			if err := ip.DelLinkByName(n.ifName(args.IfName)); err != nil {
+				if err != ip.ErrLinkNotFound {
+					return err
+				}
 			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
-		return nil
-	})
+	}
+
+	if err := teardownShim(n.DataDir, args.ContainerID); err != nil {
+		return err
+	}
+
+	// The pod link is gone, so the vlans it was the last user of can go too.
+	return teardownVlans(n.DataDir, args.ContainerID)
+}
 
-	return err
+func isEmptyDNS(dns types.DNS) bool {
+	return len(dns.Nameservers) == 0 && dns.Domain == "" && len(dns.Search) == 0 && len(dns.Options) == 0
 }
 
 func main() {
@@ -0,0 +1,152 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/vishvananda/netlink"
+)
+
+const stateShim = "shim"
+
+// Macvlan and ipvlan children can't talk to their parent, so the host gets a
+// child of its own on every master, the shim, and routes the pods of this
+// node through it.
+
+func shimName(master netlink.Link) string {
+	return fmt.Sprintf("octshim%d", master.Attrs().Index)
+}
+
+// ensureShim returns the shim of the master in sc, creating it with the same
+// link type and mode as the pods when missing.
+func ensureShim(sc *SubnetConf, mtu int) (netlink.Link, error) {
+	m, err := netlink.LinkByName(sc.Master)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup master %q: %v", sc.Master, err)
+	}
+
+	name := shimName(m)
+	if shim, err := netlink.LinkByName(name); err == nil {
+		return shim, nil
+	}
+
+	attrs := netlink.LinkAttrs{
+		MTU:         mtu,
+		Name:        name,
+		ParentIndex: m.Attrs().Index,
+	}
+	var shim netlink.Link
+	switch sc.Link {
+	case linkIpvlan:
+		mode, err := ipvlanModeFromString(sc.Mode)
+		if err != nil {
+			return nil, err
+		}
+		shim = &netlink.IPVlan{LinkAttrs: attrs, Mode: mode}
+	default:
+		mode, err := modeFromString(sc.Mode)
+		if err != nil {
+			return nil, err
+		}
+		shim = &netlink.Macvlan{LinkAttrs: attrs, Mode: mode}
+	}
+
+	if err := netlink.LinkAdd(shim); err != nil {
+		return nil, fmt.Errorf("failed to create shim %q: %v", name, err)
+	}
+	if err := netlink.LinkSetUp(shim); err != nil {
+		return nil, fmt.Errorf("failed to set shim %q up: %v", name, err)
+	}
+	log.Printf("created shim %s on %s", name, sc.Master)
+	return netlink.LinkByName(name)
+}
+
+func hostRoute(shim netlink.Link, addr net.IP) *netlink.Route {
+	bits := 8 * net.IPv6len
+	if addr.To4() != nil {
+		bits = 8 * net.IPv4len
+	}
+	return &netlink.Route{
+		LinkIndex: shim.Attrs().Index,
+		Scope:     netlink.SCOPE_LINK,
+		Dst:       &net.IPNet{IP: addr, Mask: net.CIDRMask(bits, bits)},
+	}
+}
+
+// setupShim routes the addresses of container id through the shim of its
+// master, and records them so teardownShim can remove the routes.
+func setupShim(dataDir string, sc *SubnetConf, mtu int, id string, ips []*current.IPConfig) error {
+	state, err := openHostState(dataDir)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	shim, err := ensureShim(sc, mtu)
+	if err != nil {
+		return err
+	}
+
+	addrs := []string{}
+	for _, ipc := range ips {
+		if err := netlink.RouteReplace(hostRoute(shim, ipc.Address.IP)); err != nil {
+			return fmt.Errorf("failed to add host route to %s via %q: %v", ipc.Address.IP, shim.Attrs().Name, err)
+		}
+		addrs = append(addrs, ipc.Address.IP.String())
+	}
+	return state.add(stateShim, shim.Attrs().Name, id, strings.Join(addrs, ","))
+}
+
+// teardownShim removes the host routes of container id.
+func teardownShim(dataDir string, id string) error {
+	state, err := openHostState(dataDir)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	refs, err := state.release(stateShim, id)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range refs {
+		shim, err := netlink.LinkByName(r.name)
+		if err != nil {
+			// The routes went away with the shim.
+			continue
+		}
+		for _, addr := range strings.Split(r.data, ",") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				continue
+			}
+			if err := netlink.RouteDel(hostRoute(shim, ip)); err != nil && !isNotFound(err) {
+				return fmt.Errorf("failed to delete host route to %s via %q: %v", ip, r.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	return err == syscall.ESRCH
+}
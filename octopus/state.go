@@ -0,0 +1,125 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const defaultDataDir = "/var/lib/cni/octopus"
+
+// hostState records which containers use the host side resources octopus
+// creates on demand, so that they can be removed with the last user. The
+// layout is <dir>/<kind>/<name>/<container id>, and the file holds whatever
+// the caller needs to undo its change on DEL. A <kind>/<name> directory only
+// exists for resources octopus created itself.
+type hostState struct {
+	dir  string
+	lock *os.File
+}
+
+// ref is one container using the named resource.
+type ref struct {
+	name string
+	data string
+}
+
+// openHostState opens the state directory and takes an exclusive lock on it,
+// held until Close.
+func openHostState(dir string) (*hostState, error) {
+	if dir == "" {
+		dir = defaultDataDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, "lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %q: %v", dir, err)
+	}
+	return &hostState{dir: dir, lock: f}, nil
+}
+
+func (s *hostState) Close() error {
+	return s.lock.Close()
+}
+
+// own marks kind/name as created by octopus.
+func (s *hostState) own(kind, name string) error {
+	return os.MkdirAll(filepath.Join(s.dir, kind, name), 0755)
+}
+
+// owned reports whether kind/name was created by octopus.
+func (s *hostState) owned(kind, name string) bool {
+	_, err := os.Stat(filepath.Join(s.dir, kind, name))
+	return err == nil
+}
+
+// forget drops kind/name and all its references.
+func (s *hostState) forget(kind, name string) error {
+	return os.RemoveAll(filepath.Join(s.dir, kind, name))
+}
+
+// add records that container id uses kind/name.
+func (s *hostState) add(kind, name, id, data string) error {
+	if err := os.MkdirAll(filepath.Join(s.dir, kind, name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.dir, kind, name, id), []byte(data), 0644)
+}
+
+// inUse reports whether any container still uses kind/name.
+func (s *hostState) inUse(kind, name string) bool {
+	ids, err := ioutil.ReadDir(filepath.Join(s.dir, kind, name))
+	return err == nil && len(ids) > 0
+}
+
+// release removes every reference of container id to resources of kind, and
+// returns them.
+func (s *hostState) release(kind, id string) ([]ref, error) {
+	names, err := ioutil.ReadDir(filepath.Join(s.dir, kind))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refs := []ref{}
+	for _, name := range names {
+		path := filepath.Join(s.dir, kind, name.Name(), id)
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return refs, err
+		}
+		if err := os.Remove(path); err != nil {
+			return refs, err
+		}
+		refs = append(refs, ref{name: name.Name(), data: string(data)})
+	}
+	return refs, nil
+}
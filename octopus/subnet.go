@@ -0,0 +1,103 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	linkMacvlan = "macvlan"
+	linkIpvlan  = "ipvlan"
+	linkBridge  = "bridge"
+)
+
+// SubnetConf is one entry of the "octopus" map. It accepts either the plain
+// master name, as written by install-cni.sh:
+//
+//	"10.0.0.0/24": "eth0"
+//
+// or an object which may also override the link type and its mode:
+//
+//	"10.0.0.0/24": {"master": "eth0", "link": "ipvlan", "mode": "l3"}
+//
+// A non-zero VLAN makes the master the 802.1Q sub-interface of Master, which
+// is created when missing. Bridge names the bridge of the "bridge" link type.
+type SubnetConf struct {
+	Master string `json:"master"`
+	Link   string `json:"link,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+	VLAN   int    `json:"vlan,omitempty"`
+	Bridge string `json:"bridge,omitempty"`
+}
+
+func (c *SubnetConf) UnmarshalJSON(data []byte) error {
+	var master string
+	if err := json.Unmarshal(data, &master); err == nil {
+		*c = SubnetConf{Master: master}
+		return nil
+	}
+
+	// Use an alias type so json doesn't recurse into this method.
+	type subnetConf SubnetConf
+	var conf subnetConf
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return fmt.Errorf("octopus entry must be a master name or an object: %v", err)
+	}
+	*c = SubnetConf(conf)
+	return nil
+}
+
+// withDefaults fills the link type and mode of c from the network level
+// settings when the entry doesn't specify them.
+func (c SubnetConf) withDefaults(n *NetConf) (*SubnetConf, error) {
+	if c.Link == "" {
+		c.Link = n.Link
+	}
+	if c.Link == "" {
+		c.Link = linkMacvlan
+	}
+
+	switch c.Link {
+	case linkMacvlan:
+		if c.Mode == "" {
+			c.Mode = n.Mode
+		}
+		if _, err := modeFromString(c.Mode); err != nil {
+			return nil, err
+		}
+	case linkIpvlan:
+		if c.Mode == "" {
+			c.Mode = n.IpvlanMode
+		}
+		if _, err := ipvlanModeFromString(c.Mode); err != nil {
+			return nil, err
+		}
+	case linkBridge:
+		if c.Mode != "" {
+			return nil, fmt.Errorf("bridge link has no mode, got %q", c.Mode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown link type: %q", c.Link)
+	}
+	return &c, nil
+}
+
+// usesARP is false for ipvlan in l3 and l3s mode, where the pod interface is
+// NOARP and gratuitous ARP can't be sent.
+func (c *SubnetConf) usesARP() bool {
+	return c.Link != linkIpvlan || c.Mode == "" || c.Mode == "l2"
+}
@@ -0,0 +1,59 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("octopus subnet entries", func() {
+	It("accepts both the plain master name and the object form", func() {
+		n, _, err := loadConf([]byte(`{
+			"name": "anchor",
+			"type": "octopus",
+			"link": "ipvlan",
+			"ipvlan_mode": "l3",
+			"octopus": {
+				"10.0.0.0/24": "eth0",
+				"10.0.1.0/24": {"master": "eth1", "link": "macvlan", "mode": "private"}
+			}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		sc, err := n.Octopus["10.0.0.0/24"].withDefaults(n)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*sc).To(Equal(SubnetConf{Master: "eth0", Link: "ipvlan", Mode: "l3"}))
+
+		sc, err = n.Octopus["10.0.1.0/24"].withDefaults(n)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*sc).To(Equal(SubnetConf{Master: "eth1", Link: "macvlan", Mode: "private"}))
+	})
+
+	It("rejects unknown link types and modes", func() {
+		n := &NetConf{}
+		_, err := SubnetConf{Master: "eth0", Link: "vxlan"}.withDefaults(n)
+		Expect(err).To(MatchError(`unknown link type: "vxlan"`))
+
+		_, err = SubnetConf{Master: "eth0", Link: "ipvlan", Mode: "bridge"}.withDefaults(n)
+		Expect(err).To(MatchError(`unknown ipvlan mode: "bridge"`))
+	})
+
+	It("names vlan sub-interfaces within the interface name limit", func() {
+		Expect(vlanName("eth0", 100)).To(Equal("eth0.100"))
+		Expect(vlanName("enp129s0f1np1", 4094)).To(Equal("enp129s0f1.4094"))
+		Expect(len(vlanName("enp129s0f1np1", 4094))).To(BeNumerically("<=", 15))
+	})
+})
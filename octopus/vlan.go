@@ -0,0 +1,148 @@
+// Copyright 2015 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	stateVlan = "vlan"
+	// Linux interface names are at most 15 characters.
+	maxIfNameLen = 15
+)
+
+// vlanName returns the name of the 802.1Q sub-interface of parent, the
+// familiar "eth0.100", with parent cut short if the result would be too long.
+func vlanName(parent string, vid int) string {
+	suffix := "." + strconv.Itoa(vid)
+	if len(parent)+len(suffix) > maxIfNameLen {
+		parent = parent[:maxIfNameLen-len(suffix)]
+	}
+	return parent + suffix
+}
+
+// ensureVlan makes sure the sub-interface for vid exists on parent and is up,
+// creating it if needed, and records container id as one of its users. It
+// returns the name of the sub-interface, to be used as the master.
+func ensureVlan(state *hostState, parent string, vid int, id string) (string, error) {
+	if vid < 1 || vid > 4094 {
+		return "", fmt.Errorf("invalid vlan id %d", vid)
+	}
+
+	p, err := netlink.LinkByName(parent)
+	if err != nil {
+		return "", fmt.Errorf("failed to lookup vlan parent %q: %v", parent, err)
+	}
+
+	name := vlanName(parent, vid)
+	link, err := netlink.LinkByName(name)
+	if err == nil {
+		vlan, ok := link.(*netlink.Vlan)
+		if !ok || vlan.VlanId != vid || vlan.ParentIndex != p.Attrs().Index {
+			return "", fmt.Errorf("link %q exists but is not vlan %d on %q", name, vid, parent)
+		}
+	} else {
+		vlan := &netlink.Vlan{
+			LinkAttrs: netlink.LinkAttrs{
+				Name:        name,
+				ParentIndex: p.Attrs().Index,
+			},
+			VlanId: vid,
+		}
+		// Only a link created here is owned: one that appeared meanwhile
+		// was created by someone else, as octopus holds the state lock.
+		err := netlink.LinkAdd(vlan)
+		switch {
+		case err == nil:
+			if err := state.own(stateVlan, name); err != nil {
+				return "", err
+			}
+			log.Printf("created vlan %s on %s", name, parent)
+		case err != syscall.EEXIST:
+			return "", fmt.Errorf("failed to create vlan %q: %v", name, err)
+		}
+		if link, err = netlink.LinkByName(name); err != nil {
+			return "", fmt.Errorf("failed to refetch vlan %q: %v", name, err)
+		}
+		if v, ok := link.(*netlink.Vlan); !ok || v.VlanId != vid || v.ParentIndex != p.Attrs().Index {
+			return "", fmt.Errorf("link %q exists but is not vlan %d on %q", name, vid, parent)
+		}
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return "", fmt.Errorf("failed to set %q up: %v", name, err)
+	}
+
+	// Links created by the admin are left alone, so only track our own.
+	if state.owned(stateVlan, name) {
+		if err := state.add(stateVlan, name, id, parent); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+// releaseVlans drops container id from the users of the vlan sub-interfaces
+// octopus created, and deletes those nobody uses any more.
+func releaseVlans(state *hostState, id string) error {
+	refs, err := state.release(stateVlan, id)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range refs {
+		if state.inUse(stateVlan, r.name) {
+			continue
+		}
+		if link, err := netlink.LinkByName(r.name); err == nil {
+			if err := netlink.LinkDel(link); err != nil {
+				return fmt.Errorf("failed to delete vlan %q: %v", r.name, err)
+			}
+			log.Printf("deleted unused vlan %s", r.name)
+		}
+		if err := state.forget(stateVlan, r.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupVlan is ensureVlan under the host state lock.
+func setupVlan(dataDir string, parent string, vid int, id string) (string, error) {
+	state, err := openHostState(dataDir)
+	if err != nil {
+		return "", err
+	}
+	defer state.Close()
+
+	return ensureVlan(state, parent, vid, id)
+}
+
+// teardownVlans is releaseVlans under the host state lock.
+func teardownVlans(dataDir string, id string) error {
+	state, err := openHostState(dataDir)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	return releaseVlans(state, id)
+}